@@ -0,0 +1,49 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/http"
+
+	avatars "github.com/lirios/website/avatars"
+	events "github.com/lirios/website/events"
+	geoip "github.com/lirios/website/geoip"
+	invite "github.com/lirios/website/invite"
+	mirrors "github.com/lirios/website/mirrors"
+	server "github.com/lirios/website/server"
+)
+
+// Context gives the handlers the settings and caches of the server
+// along with the services of the site.
+type Context interface {
+	server.Context
+	Mirrors() *mirrors.Pool
+	GeoIP() *geoip.Database
+	Avatars() *avatars.Cache
+	TeamEvents() *events.Broker
+	Invites() *invite.Guard
+}
+
+// HandlerFunc is a server.HandlerFunc given the context of the API.
+type HandlerFunc func(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error)
@@ -0,0 +1,131 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+	avatars "github.com/lirios/website/avatars"
+	events "github.com/lirios/website/events"
+	geoip "github.com/lirios/website/geoip"
+	invite "github.com/lirios/website/invite"
+	mirrors "github.com/lirios/website/mirrors"
+	server "github.com/lirios/website/server"
+)
+
+// testContext is a Context for tests.
+type testContext struct {
+	settings          *server.Settings
+	teamCache         *server.Cache
+	contributorsCache *server.Cache
+	releasesCache     *server.Cache
+	mirrors           *mirrors.Pool
+	geoIP             *geoip.Database
+	avatars           *avatars.Cache
+	teamEvents        *events.Broker
+	invites           *invite.Guard
+}
+
+func (c *testContext) Settings() *server.Settings {
+	return c.settings
+}
+
+func (c *testContext) TeamCache() *server.Cache {
+	return c.teamCache
+}
+
+func (c *testContext) ContributorsCache() *server.Cache {
+	return c.contributorsCache
+}
+
+func (c *testContext) ReleasesCache() *server.Cache {
+	return c.releasesCache
+}
+
+func (c *testContext) Mirrors() *mirrors.Pool {
+	return c.mirrors
+}
+
+func (c *testContext) GeoIP() *geoip.Database {
+	return c.geoIP
+}
+
+func (c *testContext) Avatars() *avatars.Cache {
+	return c.avatars
+}
+
+func (c *testContext) TeamEvents() *events.Broker {
+	return c.teamEvents
+}
+
+func (c *testContext) Invites() *invite.Guard {
+	return c.invites
+}
+
+// newTestContext returns a context talking to the given Slack stand-in.
+func newTestContext(slackURL string) *testContext {
+	c := &testContext{settings: &server.Settings{}}
+	c.settings.Slack.URL = slackURL
+	c.settings.Slack.Token = "xoxp-test"
+	c.teamCache = server.NewCache(time.Hour, func() (interface{}, error) {
+		return FetchTeam(c)
+	})
+	c.teamCache.OnUpdate(func(old, new interface{}) {
+		PublishTeamChanges(c, old, new)
+	})
+	c.contributorsCache = server.NewCache(time.Hour, func() (interface{}, error) {
+		return FetchContributors(c)
+	})
+	c.releasesCache = server.NewCache(time.Hour, func() (interface{}, error) {
+		return FetchReleases(c)
+	})
+	c.mirrors = mirrors.NewPool(nil, mirrors.Options{})
+	c.teamEvents = events.NewBroker(0, 0)
+	c.invites = invite.NewGuard()
+	return c
+}
+
+// serve runs a handler the way the application does.
+func serve(c Context, handler HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	code, body, err := handler(c, w, r)
+	server.Respond(w, r, code, body, err)
+	return w
+}
+
+// serveRoute runs a handler behind a router matching pattern,
+// for the handler to get the variables of the route.
+func serveRoute(c Context, pattern string, handler HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		code, body, err := handler(c, w, r)
+		server.Respond(w, r, code, body, err)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,105 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	avatars "github.com/lirios/website/avatars"
+	server "github.com/lirios/website/server"
+)
+
+// Cache lifetimes of the avatars: versioned URLs change with the
+// image so they are cached for good, the others shortly.
+const (
+	avatarMaxAge         = 365 * 24 * 60 * 60
+	avatarUnversionedAge = 5 * 60
+)
+
+// avatarURL returns the URL of the avatar of a member on the proxy,
+// the version making sure browsers fetch it again when it changes.
+func avatarURL(m filteredMember, baseURL string) string {
+	if len(m.Avatars) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/avatars/" + url.PathEscape(m.ID) + "?v=" + m.Avatars.Version()
+}
+
+// AvatarHandler is a http handler serving the avatars of the members,
+// resized to ?size= pixels. Images are proxied so that the chat service
+// doesn't learn who visits the site.
+func AvatarHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	size := avatars.DefaultSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return 0, nil, server.BadRequest("invalid size " + strconv.Quote(s))
+		}
+	}
+	size = avatars.RoundSize(size)
+
+	cached, err := c.TeamCache().Get()
+	if err != nil {
+		return 0, nil, upstreamError(w, err)
+	}
+
+	// Only the avatars of published members are served
+	id := mux.Vars(r)["id"]
+	var sources avatars.Sources
+	for _, m := range publish(cached.(filteredMembers), c.Settings()) {
+		if m.ID == id {
+			sources = m.Avatars
+			break
+		}
+	}
+	source, ok := sources.Closest(size)
+	if !ok {
+		return 0, nil, server.NotFound("avatar not found")
+	}
+
+	image, err := c.Avatars().Get(source.URL, size, avatars.AcceptsWebP(r.Header.Get("Accept")))
+	if err != nil {
+		log.Printf("Failed to get avatar of %s: %v", id, err)
+		return 0, nil, server.NewError(http.StatusBadGateway, "upstream_error", "The avatar could not be retrieved")
+	}
+
+	cacheControl := "public, max-age=" + strconv.Itoa(avatarUnversionedAge)
+	if r.URL.Query().Get("v") == sources.Version() {
+		cacheControl = "public, max-age=" + strconv.Itoa(avatarMaxAge) + ", immutable"
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", image.ETag)
+	if r.Header.Get("If-None-Match") == image.ETag {
+		return http.StatusNotModified, nil, nil
+	}
+	w.Header().Set("Content-Type", image.ContentType)
+	return http.StatusOK, image.Data, nil
+}
@@ -0,0 +1,183 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	avatars "github.com/lirios/website/avatars"
+	server "github.com/lirios/website/server"
+)
+
+// fakeImages serves a PNG image of the size in the path, counting requests.
+type fakeImages struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeImages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	var size int
+	if _, err := fmt.Sscanf(r.URL.Path, "/%d.png", &size); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+	}
+	m.Set(0, 0, color.NRGBA{0x80, 0, 0, 0xff})
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, m)
+}
+
+// newAvatarContext returns a context with a member whose avatar
+// comes in 72 and 192 pixels.
+func newAvatarContext(t *testing.T) (*testContext, *fakeImages, func()) {
+	images := &fakeImages{}
+	is := httptest.NewServer(images)
+	slack := &fakeSlack{body: `{"ok": true, "members": [{"id": "U1", "name": "alice",
+		"profile": {"image_72": "` + is.URL + `/72.png", "image_192": "` + is.URL + `/192.png"}}]}`}
+	ss := httptest.NewServer(slack)
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestContext(ss.URL)
+	c.avatars, err = avatars.NewCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, images, func() {
+		is.Close()
+		ss.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func getAvatar(c Context, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	return serveRoute(c, "/avatars/{id}", AvatarHandler, r)
+}
+
+func TestAvatarHandler(t *testing.T) {
+	c, images, cleanup := newAvatarContext(t)
+	defer cleanup()
+
+	_, data := getTeam(t, c)
+	target := data.Members[0].Image
+	if !strings.HasPrefix(target, "/avatars/U1?v=") {
+		t.Fatalf("expected a proxied image, got %q", target)
+	}
+
+	w := getAvatar(c, target+"&size=40", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("expected a JPEG image, got %q", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("expected a versioned image to be cached for good, got %q", cc)
+	}
+	if w.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected the image to vary with Accept")
+	}
+	m, _, err := image.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := m.Bounds().Dx(); size != 48 {
+		t.Errorf("expected the size to be rounded to 48, got %d", size)
+	}
+
+	// Browsers that support WebP get it when smaller
+	w = getAvatar(c, "/avatars/U1?size=48", http.Header{"Accept": {"image/webp,*/*"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/webp" && ct != "image/jpeg" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); strings.Contains(cc, "immutable") {
+		t.Errorf("expected an unversioned image to be cached shortly, got %q", cc)
+	}
+
+	// Each source is fetched once, resized copies are cached too
+	getAvatar(c, "/avatars/U1?size=48", nil)
+	getAvatar(c, "/avatars/U1?size=100", nil)
+	if images.calls != 2 {
+		t.Errorf("expected the 72 and 192 pixels sources to be fetched once, got %d requests", images.calls)
+	}
+}
+
+func TestAvatarHandlerNotModified(t *testing.T) {
+	c, _, cleanup := newAvatarContext(t)
+	defer cleanup()
+
+	w := getAvatar(c, "/avatars/U1", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag, got status %d", w.Code)
+	}
+	w = getAvatar(c, "/avatars/U1", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", w.Code)
+	}
+}
+
+func TestAvatarHandlerErrors(t *testing.T) {
+	c, _, cleanup := newAvatarContext(t)
+	defer cleanup()
+
+	if w := getAvatar(c, "/avatars/U1?size=big", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid size, got %d", w.Code)
+	}
+	if w := getAvatar(c, "/avatars/U9", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown member, got %d", w.Code)
+	}
+
+	// Avatars hidden by the privacy policy aren't served either
+	c.settings.Privacy.Image = server.Hide
+	if w := getAvatar(c, "/avatars/U1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a hidden avatar, got %d", w.Code)
+	}
+}
@@ -0,0 +1,336 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	server "github.com/lirios/website/server"
+)
+
+// gitHubPageSize is the number of items requested for each page.
+const gitHubPageSize = 100
+
+// gitHubRetryAfter is how long to wait when rate limited
+// without being told for how long.
+const gitHubRetryAfter = time.Minute
+
+// gitHubRateLimitError is returned when GitHub is rate limiting the requests.
+type gitHubRateLimitError struct {
+	Delay time.Duration
+}
+
+func (e *gitHubRateLimitError) Error() string {
+	return fmt.Sprintf("github: rate limited, retry after %s", e.Delay)
+}
+
+// RetryAfter returns how long to wait before trying again.
+func (e *gitHubRateLimitError) RetryAfter() time.Duration {
+	return e.Delay
+}
+
+// gitHubRateLimit returns the error of a response rejected because of
+// the primary or secondary rate limits, or nil. Both answer with 403
+// or 429, telling when to try again by the Retry-After header or the
+// time the limit is reset.
+func gitHubRateLimit(resp *http.Response, now time.Time) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return &gitHubRateLimitError{time.Duration(seconds) * time.Second}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		delay := gitHubRetryAfter
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			delay = time.Unix(reset, 0).Sub(now)
+		}
+		if delay <= 0 {
+			delay = time.Second
+		}
+		return &gitHubRateLimitError{delay}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &gitHubRateLimitError{gitHubRetryAfter}
+	}
+	return nil
+}
+
+// gitHubRepository is a repository of the organization.
+type gitHubRepository struct {
+	Name string `json:"name"`
+	Fork bool   `json:"fork"`
+}
+
+// gitHubUser is a GitHub account.
+type gitHubUser struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+	Type      string `json:"type"`
+}
+
+// gitHubContributorStats is the commit activity of a contributor to a repository.
+type gitHubContributorStats struct {
+	Author *gitHubUser `json:"author"`
+	Total  int         `json:"total"`
+	Weeks  []struct {
+		Week    int64 `json:"w"`
+		Commits int   `json:"c"`
+	} `json:"weeks"`
+}
+
+// gitHubContributor is a contributor to a repository without statistics.
+type gitHubContributor struct {
+	gitHubUser
+	Contributions int `json:"contributions"`
+}
+
+// contributor is a contributor to the organization repositories.
+type contributor struct {
+	Login             string     `json:"login"`
+	Image             string     `json:"image"`
+	URL               string     `json:"url"`
+	Commits           int        `json:"commits"`
+	FirstContribution *time.Time `json:"first_contribution,omitempty"`
+	LastContribution  *time.Time `json:"last_contribution,omitempty"`
+	Repositories      []string   `json:"repositories"`
+}
+
+// contributors is a list of contributors.
+type contributors []*contributor
+
+// contributorListData is the response of the contributors API.
+type contributorListData struct {
+	Ok           bool         `json:"ok"`
+	Contributors contributors `json:"contributors"`
+}
+
+// Len returns the length of the slice.
+func (slice contributors) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice contributors) Less(i, j int) bool {
+	if slice[i].Commits != slice[j].Commits {
+		return slice[i].Commits > slice[j].Commits
+	}
+	return slice[i].Login < slice[j].Login
+}
+
+// Swap swaps two slice items.
+func (slice contributors) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// gitHubClient performs requests to the GitHub REST API.
+type gitHubClient struct {
+	baseURL string
+	token   string
+}
+
+// get decodes the JSON response of an API endpoint into v and returns
+// the status code, so that callers can handle 202 and 204 responses.
+func (g gitHubClient) get(path string, v interface{}) (int, error) {
+	req, err := http.NewRequest("GET", g.baseURL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if g.token != "" {
+		req.Header.Set("Authorization", "token "+g.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusAccepted, http.StatusNoContent:
+		return resp.StatusCode, nil
+	}
+	if err := gitHubRateLimit(resp, time.Now()); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, fmt.Errorf("github: unexpected response %q for %s", resp.Status, req.URL.Path)
+}
+
+// repositories returns the repositories of an organization, excluding forks.
+func (g gitHubClient) repositories(org string) ([]gitHubRepository, error) {
+	var result []gitHubRepository
+	for page := 1; ; page++ {
+		var repos []gitHubRepository
+		path := "/orgs/" + url.PathEscape(org) + "/repos?type=public&per_page=" + strconv.Itoa(gitHubPageSize) + "&page=" + strconv.Itoa(page)
+		if _, err := g.get(path, &repos); err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			if !repo.Fork {
+				result = append(result, repo)
+			}
+		}
+		if len(repos) < gitHubPageSize {
+			return result, nil
+		}
+	}
+}
+
+// contributors returns the contributors to a repository.
+// Statistics are used when GitHub has them, otherwise contributors come
+// without the first and last contribution dates.
+func (g gitHubClient) contributors(org, repo string) ([]gitHubContributorStats, error) {
+	prefix := "/repos/" + url.PathEscape(org) + "/" + url.PathEscape(repo)
+
+	var stats []gitHubContributorStats
+	code, err := g.get(prefix+"/stats/contributors", &stats)
+	if err != nil || code != http.StatusAccepted {
+		return stats, err
+	}
+
+	// GitHub is still computing the statistics
+	for page := 1; ; page++ {
+		var list []gitHubContributor
+		path := prefix + "/contributors?per_page=" + strconv.Itoa(gitHubPageSize) + "&page=" + strconv.Itoa(page)
+		code, err := g.get(path, &list)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			stats = append(stats, gitHubContributorStats{Author: &list[i].gitHubUser, Total: list[i].Contributions})
+		}
+		if code == http.StatusNoContent || len(list) < gitHubPageSize {
+			return stats, nil
+		}
+	}
+}
+
+// FetchContributors aggregates the contributors to all the repositories
+// of the configured GitHub organization, ready to be cached.
+func FetchContributors(c Context) (interface{}, error) {
+	settings := c.Settings().GitHub
+	client := gitHubClient{strings.TrimSuffix(settings.URL, "/"), settings.Token}
+	if client.baseURL == "" {
+		client.baseURL = server.DefaultGitHubURL
+	}
+	org := settings.Organization
+	if org == "" {
+		org = server.DefaultGitHubOrganization
+	}
+
+	repos, err := client.repositories(org)
+	if err != nil {
+		return nil, err
+	}
+
+	// Contributors are deduplicated by login. Repositories that fail
+	// are left out as long as some of them succeed
+	byLogin := make(map[string]*contributor)
+	result := contributors{}
+	var failed []string
+	var lastErr error
+	for _, repo := range repos {
+		if _, ok := lastErr.(*gitHubRateLimitError); ok {
+			failed = append(failed, repo.Name)
+			continue
+		}
+		stats, err := client.contributors(org, repo.Name)
+		if err != nil {
+			failed = append(failed, repo.Name)
+			lastErr = err
+			continue
+		}
+		for _, s := range stats {
+			if s.Author == nil || s.Author.Type == "Bot" || s.Total == 0 {
+				continue
+			}
+			login := strings.ToLower(s.Author.Login)
+			contrib, ok := byLogin[login]
+			if !ok {
+				contrib = &contributor{
+					Login: s.Author.Login,
+					Image: s.Author.AvatarURL,
+					URL:   s.Author.HTMLURL,
+				}
+				byLogin[login] = contrib
+				result = append(result, contrib)
+			}
+			contrib.Commits += s.Total
+			contrib.Repositories = append(contrib.Repositories, repo.Name)
+			for _, week := range s.Weeks {
+				if week.Commits == 0 {
+					continue
+				}
+				t := time.Unix(week.Week, 0).UTC()
+				if contrib.FirstContribution == nil || t.Before(*contrib.FirstContribution) {
+					contrib.FirstContribution = &t
+				}
+				if contrib.LastContribution == nil || t.After(*contrib.LastContribution) {
+					contrib.LastContribution = &t
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		if len(failed) == len(repos) {
+			return nil, lastErr
+		}
+		log.Printf("Failed to get the contributors to %s: %v", strings.Join(failed, ", "), lastErr)
+	}
+
+	// Most active contributors first
+	sort.Sort(result)
+
+	return result, nil
+}
+
+// ContributorsHandler is a http handler for the contributors API.
+func ContributorsHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	// Contributors are cached, collecting them takes several requests
+	cached, err := c.ContributorsCache().Get()
+	if e, ok := err.(*gitHubRateLimitError); ok {
+		seconds := int((e.Delay + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		return 0, nil, server.Unavailable("GitHub is rate limiting requests, try again later").
+			WithDetails(map[string]int{"retry_after": seconds})
+	}
+	if err != nil {
+		return 0, nil, server.UpstreamError(err)
+	}
+
+	return http.StatusOK, contributorListData{Ok: true, Contributors: cached.(contributors)}, nil
+}
@@ -0,0 +1,195 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeGitHub is a stand-in for the GitHub REST API.
+func fakeGitHub(t *testing.T, calls *int) *httptest.Server {
+	reply := func(w http.ResponseWriter, body string) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/lirios/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token gh-test" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		reply(w, `[{"name": "shell"}, {"name": "fluid"}, {"name": "qtbase", "fork": true}]`)
+	})
+	mux.HandleFunc("/repos/lirios/shell/stats/contributors", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, `[
+			{"author": {"login": "Alice", "avatar_url": "https://example.com/alice.png", "html_url": "https://github.com/Alice"},
+			 "total": 3, "weeks": [{"w": 1483228800, "c": 1}, {"w": 1483833600, "c": 0}, {"w": 1484438400, "c": 2}]},
+			{"author": {"login": "ci", "type": "Bot"}, "total": 10},
+			{"author": null, "total": 1}
+		]`)
+	})
+	mux.HandleFunc("/repos/lirios/fluid/stats/contributors", func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/repos/lirios/fluid/contributors", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, `[
+			{"login": "bob", "html_url": "https://github.com/bob", "contributions": 7},
+			{"login": "alice", "html_url": "https://github.com/alice", "contributions": 1}
+		]`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func getContributors(t *testing.T, c *testContext) (int, contributorListData) {
+	r := httptest.NewRequest("GET", "/api/contributors", nil)
+	w := serve(c, ContributorsHandler, r)
+	var data contributorListData
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, data
+}
+
+func TestContributorsHandler(t *testing.T) {
+	calls := 0
+	ts := fakeGitHub(t, &calls)
+	defer ts.Close()
+
+	c := newTestContext("")
+	c.settings.GitHub.URL = ts.URL
+	c.settings.GitHub.Token = "gh-test"
+
+	code, data := getContributors(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(data.Contributors) != 2 {
+		t.Fatalf("expected 2 contributors, got %d", len(data.Contributors))
+	}
+
+	bob, alice := data.Contributors[0], data.Contributors[1]
+	if bob.Login != "bob" || bob.Commits != 7 || bob.FirstContribution != nil {
+		t.Errorf("unexpected contributor %+v", bob)
+	}
+	if alice.Login != "Alice" || alice.Commits != 4 || len(alice.Repositories) != 2 {
+		t.Errorf("unexpected contributor %+v", alice)
+	}
+	first, last := time.Unix(1483228800, 0), time.Unix(1484438400, 0)
+	if alice.FirstContribution == nil || !alice.FirstContribution.Equal(first) {
+		t.Errorf("expected first contribution %v, got %v", first, alice.FirstContribution)
+	}
+	if alice.LastContribution == nil || !alice.LastContribution.Equal(last) {
+		t.Errorf("expected last contribution %v, got %v", last, alice.LastContribution)
+	}
+
+	// Further requests are served from the cache
+	before := calls
+	getContributors(t, c)
+	if calls != before {
+		t.Errorf("expected no further calls to GitHub, got %d", calls-before)
+	}
+}
+
+func TestContributorsHandlerPartial(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/lirios/repos", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "shell"}, {"name": "fluid"}]`))
+	})
+	mux.HandleFunc("/repos/lirios/shell/stats/contributors", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"author": {"login": "alice"}, "total": 3}]`))
+	})
+	mux.HandleFunc("/repos/lirios/fluid/stats/contributors", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := newTestContext("")
+	c.settings.GitHub.URL = ts.URL
+
+	// The repositories that can be read are still served
+	code, data := getContributors(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(data.Contributors) != 1 || data.Contributors[0].Login != "alice" {
+		t.Errorf("unexpected contributors %+v", data.Contributors)
+	}
+}
+
+func TestContributorsHandlerRateLimited(t *testing.T) {
+	tests := []struct {
+		status int
+		header map[string]string
+		delay  int
+	}{
+		{http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "reset"}, 120},
+		{http.StatusForbidden, map[string]string{"Retry-After": "30"}, 30},
+		{http.StatusTooManyRequests, nil, 60},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range test.header {
+				if value == "reset" {
+					value = strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10)
+				}
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+		}))
+		c := newTestContext("")
+		c.settings.GitHub.URL = ts.URL
+
+		w := serve(c, ContributorsHandler, httptest.NewRequest("GET", "/api/contributors", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%d %v: expected status 503, got %d", test.status, test.header, w.Code)
+		}
+		// The reset time is rounded to the second
+		delay, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		if delay < test.delay-1 || delay > test.delay {
+			t.Errorf("%d %v: expected to retry after %ds, got %q", test.status, test.header, test.delay, w.Header().Get("Retry-After"))
+		}
+		ts.Close()
+	}
+
+	// Other refusals are not mistaken for rate limiting
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	c := newTestContext("")
+	c.settings.GitHub.URL = ts.URL
+	if code, _ := getContributors(t, c); code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", code)
+	}
+}
@@ -0,0 +1,115 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	server "github.com/lirios/website/server"
+)
+
+// clientIP returns the address of the client. Proxy headers are only
+// taken into account when the server is configured to trust them and
+// the request comes from a trusted proxy.
+func clientIP(c Context, r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	settings := c.Settings().Server
+	if !settings.TrustProxy || !isTrustedProxy(settings.TrustedProxy, ip) {
+		return ip
+	}
+
+	// Each proxy appends the address it got the request from, anything
+	// on the left of the last one of ours was sent by the client
+	if forwarded := r.Header["X-Forwarded-For"]; len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if len(settings.TrustedProxy) == 0 || !isTrustedProxy(settings.TrustedProxy, hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := net.ParseIP(r.Header.Get("X-Real-IP")); real != nil {
+		return real
+	}
+	return ip
+}
+
+// isTrustedProxy returns whether ip is one of the proxies, given as
+// addresses or networks. Any address is trusted when none is given.
+func isTrustedProxy(proxies []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if len(proxies) == 0 {
+		return true
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadHandler is a http handler redirecting to a release file
+// on the closest healthy mirror.
+func DownloadHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	vars := mux.Vars(r)
+
+	catalog, err := releaseCatalog(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	release := catalog.Find(vars["release"])
+	if release == nil || !release.HasFile(vars["file"]) {
+		return 0, nil, server.NotFound("file not found")
+	}
+
+	mirror := c.Mirrors().Pick(c.GeoIP().Country(clientIP(c, r)))
+	if mirror == nil {
+		return 0, nil, server.Unavailable("no download mirror available")
+	}
+
+	// Another mirror might be picked next time
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", mirror.FileURL(release.Channel+"/"+release.Version+"/"+vars["file"]))
+	return http.StatusFound, nil, nil
+}
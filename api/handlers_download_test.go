@@ -0,0 +1,130 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	geoip "github.com/lirios/website/geoip"
+	mirrors "github.com/lirios/website/mirrors"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trust     bool
+		proxies   []string
+		remote    string
+		forwarded []string
+		realIP    string
+		ip        string
+	}{
+		{false, nil, "192.0.2.1:1234", []string{"198.51.100.1"}, "", "192.0.2.1"},
+		{true, nil, "192.0.2.1:1234", nil, "", "192.0.2.1"},
+		{true, nil, "192.0.2.1:1234", nil, "198.51.100.1", "198.51.100.1"},
+		// Only the entry appended by the proxy can be trusted
+		{true, nil, "10.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{true, nil, "10.0.0.1:1234", []string{"203.0.113.1", "198.51.100.1"}, "", "198.51.100.1"},
+		{true, nil, "10.0.0.1:1234", []string{"not an address"}, "", "10.0.0.1"},
+		// Trusted proxies are skipped, others are not trusted at all
+		{true, []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"}, "", "198.51.100.1"},
+		{true, []string{"10.0.0.1"}, "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1", "192.0.2.1"},
+		{true, []string{"2001:db8::/32"}, "[2001:db8::1]:1234", []string{"198.51.100.1"}, "", "198.51.100.1"},
+	}
+	for _, test := range tests {
+		c := newTestContext("")
+		c.settings.Server.TrustProxy = test.trust
+		c.settings.Server.TrustedProxy = test.proxies
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		r.Header["X-Forwarded-For"] = test.forwarded
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := clientIP(c, r); !ip.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("%+v: expected %s, got %s", test, test.ip, ip)
+		}
+	}
+}
+
+func TestDownloadHandler(t *testing.T) {
+	c, cleanup := newReleasesContext(t)
+	defer cleanup()
+	db, err := geoip.Read(strings.NewReader("1.0.0.0,1.0.0.255,AU\n2.16.0.0,2.16.255.255,IT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.geoIP = db
+	c.mirrors = mirrors.NewPool([]*mirrors.Mirror{
+		{Name: "au", URL: "https://au.example.org/liri/", Country: "AU"},
+		{Name: "it", URL: "https://it.example.org/liri", Country: "IT"},
+	}, mirrors.Options{})
+	c.settings.Server.TrustProxy = true
+
+	download := func(target, remote, forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return serveRoute(c, "/download/{release}/{file}", DownloadHandler, r)
+	}
+
+	tests := []struct {
+		target, remote, forwarded string
+		code                      int
+		location                  string
+	}{
+		{"/download/0.9.0/liri-0.9.0.iso", "2.16.1.1:1234", "", http.StatusFound, "https://it.example.org/liri/stable/0.9.0/liri-0.9.0.iso"},
+		{"/download/0.9.0/liri-0.9.0.iso", "1.0.0.1:1234", "", http.StatusFound, "https://au.example.org/liri/stable/0.9.0/liri-0.9.0.iso"},
+		// The client can't choose its country
+		{"/download/0.9.0/liri-0.9.0.iso", "10.0.0.1:1234", "1.0.0.1, 2.16.1.1", http.StatusFound, "https://it.example.org/liri/stable/0.9.0/liri-0.9.0.iso"},
+		{"/download/1.0.0/liri-0.9.0.iso", "2.16.1.1:1234", "", http.StatusNotFound, ""},
+		{"/download/0.9.0/liri-0.8.0.iso", "2.16.1.1:1234", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := download(test.target, test.remote, test.forwarded)
+		if w.Code != test.code {
+			t.Errorf("%s from %s: expected status %d, got %d", test.target, test.remote, test.code, w.Code)
+			continue
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("%s from %s: expected location %q, got %q", test.target, test.remote, test.location, location)
+		}
+	}
+
+	// Clients are not sent to mirrors that are down
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	c.mirrors = mirrors.NewPool([]*mirrors.Mirror{{Name: "down", URL: down.URL, Country: "IT"}}, mirrors.Options{})
+	c.mirrors.Check()
+	if w := download("/download/0.9.0/liri-0.9.0.iso", "2.16.1.1:1234", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 when no mirror is up, got %d", w.Code)
+	}
+}
@@ -0,0 +1,198 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	invite "github.com/lirios/website/invite"
+	server "github.com/lirios/website/server"
+)
+
+// Statuses of an invitation.
+const (
+	inviteInvited       = "invited"
+	inviteAlreadyMember = "already_member"
+	inviteRejected      = "rejected"
+)
+
+// Reasons for rejecting an invitation.
+const (
+	reasonInvalidEmail    = "invalid_email"
+	reasonDisposableEmail = "disposable_email"
+	reasonInvalidProof    = "invalid_proof"
+	reasonRefused         = "refused"
+)
+
+// maxInviteRequestSize limits the size of the invitation requests.
+const maxInviteRequestSize = 4096
+
+// errInvitesUnsupported is returned by inviters that can't send
+// invitations with the current settings.
+var errInvitesUnsupported = errors.New("invitations are not configured for the chat service")
+
+// inviter invites people to the chat service by email.
+type inviter interface {
+	// Invite returns the status of the invitation.
+	Invite(c Context, email string) (string, error)
+}
+
+// inviteRequest is an invitation request, with the solution
+// of the proof of work challenge when one is asked.
+type inviteRequest struct {
+	Email     string `json:"email"`
+	Challenge string `json:"challenge"`
+	Nonce     string `json:"nonce"`
+}
+
+// inviteData is the outcome of an invitation request.
+type inviteData struct {
+	Ok      bool   `json:"ok"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+}
+
+// proofOfWorkData is a proof of work challenge: clients look for a nonce
+// such that the SHA-256 hash of challenge:nonce starts with difficulty
+// zero bits.
+type proofOfWorkData struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// inviteInfoData tells clients how to ask for an invitation.
+type inviteInfoData struct {
+	Ok          bool             `json:"ok"`
+	Enabled     bool             `json:"enabled"`
+	ProofOfWork *proofOfWorkData `json:"proof_of_work,omitempty"`
+}
+
+// rejectInvite returns the response to a rejected invitation.
+func rejectInvite(reason, message string) (int, interface{}, error) {
+	return http.StatusUnprocessableEntity, inviteData{Status: inviteRejected, Reason: reason, Message: message}, nil
+}
+
+// teamInviter returns the inviter of the configured chat service.
+func teamInviter(c Context) (inviter, error) {
+	provider, err := teamProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	if i, ok := provider.(inviter); ok {
+		return i, nil
+	}
+	return nil, errInvitesUnsupported
+}
+
+// InviteInfoHandler is a http handler telling whether invitations
+// are open, with a new proof of work challenge when one is asked.
+func InviteInfoHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+	w.Header().Set("Cache-Control", "no-store")
+
+	settings := c.Settings().Invite
+	data := inviteInfoData{Ok: true, Enabled: settings.Enabled}
+	if settings.Enabled && settings.ProofOfWork > 0 {
+		data.ProofOfWork = &proofOfWorkData{
+			Challenge:  c.Invites().Challenge(time.Now()),
+			Difficulty: settings.ProofOfWork,
+		}
+	}
+	return http.StatusOK, data, nil
+}
+
+// InviteHandler is a http handler inviting people to the chat service.
+// The email is taken from a JSON object or a form.
+func InviteHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	settings := c.Settings().Invite
+	if !settings.Enabled {
+		return 0, nil, server.NotFound("Invitations are not open")
+	}
+	inviter, err := teamInviter(c)
+	if err != nil {
+		return 0, nil, server.Unavailable("Invitations are not available")
+	}
+
+	var request inviteRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxInviteRequestSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			return 0, nil, server.BadRequest("The request is not valid JSON")
+		}
+	} else {
+		request.Email = r.PostFormValue("email")
+		request.Challenge = r.PostFormValue("challenge")
+		request.Nonce = r.PostFormValue("nonce")
+	}
+
+	// Limit every attempt, so that addresses can't be probed either
+	now := time.Now()
+	if settings.RateLimit > 0 {
+		window := settings.RateWindow.OrDefault(server.DefaultInviteRateWindow)
+		if ok, wait := c.Invites().Allow(clientIP(c, r).String(), settings.RateLimit, window, now); !ok {
+			seconds := int(wait/time.Second) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			return 0, nil, server.NewError(http.StatusTooManyRequests, "rate_limited",
+				"Too many invitations were asked, try again later").
+				WithDetails(map[string]int{"retry_after": seconds})
+		}
+	}
+
+	email, err := invite.ParseEmail(request.Email)
+	if err != nil {
+		return rejectInvite(reasonInvalidEmail, "The email address is not valid")
+	}
+	if err := invite.CheckDomain(email, settings.Blocklist); err != nil {
+		return rejectInvite(reasonDisposableEmail, "Addresses of this domain are not accepted")
+	}
+	if settings.ProofOfWork > 0 {
+		if err := c.Invites().Verify(request.Challenge, request.Nonce, settings.ProofOfWork, now); err != nil {
+			return rejectInvite(reasonInvalidProof, "The proof of work is not valid, ask for a new challenge")
+		}
+	}
+
+	status, err := inviter.Invite(c, email)
+	switch {
+	case err == errInvitesUnsupported:
+		return 0, nil, server.Unavailable("Invitations are not available")
+	case err != nil:
+		return 0, nil, upstreamError(w, err)
+	}
+	switch status {
+	case inviteAlreadyMember:
+		return http.StatusOK, inviteData{Ok: true, Status: status, Message: "You are already a member, welcome back"}, nil
+	case inviteRejected:
+		return rejectInvite(reasonRefused, "The chat service refused to invite this address")
+	}
+	return http.StatusOK, inviteData{Ok: true, Status: inviteInvited, Message: "Check your email for the invitation"}, nil
+}
@@ -0,0 +1,268 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	invite "github.com/lirios/website/invite"
+)
+
+// fakeInviteSlack is a stand-in for the Slack invitations API.
+type fakeInviteSlack struct {
+	mu      sync.Mutex
+	invited []string
+}
+
+func (f *fakeInviteSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/users.admin.invite" || r.Method != "POST" || r.Header.Get("Authorization") != "Bearer xoxp-test" {
+		http.NotFound(w, r)
+		return
+	}
+	email := r.PostFormValue("email")
+	switch email {
+	case "member@example.org":
+		w.Write([]byte(`{"ok": false, "error": "already_in_team"}`))
+	case "pending@example.org":
+		w.Write([]byte(`{"ok": false, "error": "already_invited"}`))
+	case "bounce@example.org":
+		w.Write([]byte(`{"ok": false, "error": "invalid_email"}`))
+	case "limit@example.org":
+		w.Write([]byte(`{"ok": false, "error": "invalid_auth"}`))
+	default:
+		f.mu.Lock()
+		f.invited = append(f.invited, email)
+		f.mu.Unlock()
+		w.Write([]byte(`{"ok": true}`))
+	}
+}
+
+// newInviteContext returns a context accepting invitations
+// to a fake Slack workspace.
+func newInviteContext(t *testing.T) (*testContext, *fakeInviteSlack, func()) {
+	fake := &fakeInviteSlack{}
+	ts := httptest.NewServer(fake)
+	c := newTestContext(ts.URL)
+	c.settings.Invite.Enabled = true
+	c.settings.Invite.RateLimit = 100
+	return c, fake, ts.Close
+}
+
+// postInvite asks for an invitation from an address.
+func postInvite(t *testing.T, c *testContext, remoteAddr string, body interface{}) (int, inviteData) {
+	encoded, _ := json.Marshal(body)
+	r := httptest.NewRequest("POST", "/api/invite", strings.NewReader(string(encoded)))
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = remoteAddr
+	w := serve(c, InviteHandler, r)
+	var data inviteData
+	json.Unmarshal(w.Body.Bytes(), &data)
+	return w.Code, data
+}
+
+func TestInviteHandler(t *testing.T) {
+	c, fake, cleanup := newInviteContext(t)
+	defer cleanup()
+
+	tests := []struct {
+		email  string
+		code   int
+		status string
+		reason string
+	}{
+		{"alice@example.org", http.StatusOK, inviteInvited, ""},
+		{"pending@example.org", http.StatusOK, inviteInvited, ""},
+		{"member@example.org", http.StatusOK, inviteAlreadyMember, ""},
+		{"bounce@example.org", http.StatusUnprocessableEntity, inviteRejected, reasonRefused},
+		{"not an address", http.StatusUnprocessableEntity, inviteRejected, reasonInvalidEmail},
+		{"spam@mailinator.com", http.StatusUnprocessableEntity, inviteRejected, reasonDisposableEmail},
+	}
+	for _, test := range tests {
+		code, data := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: test.email})
+		if code != test.code || data.Status != test.status || data.Reason != test.reason {
+			t.Errorf("%s: expected %d %s %s, got %d %+v", test.email, test.code, test.status, test.reason, code, data)
+		}
+	}
+	if len(fake.invited) != 1 || fake.invited[0] != "alice@example.org" {
+		t.Errorf("expected only alice to be invited, got %v", fake.invited)
+	}
+
+	// Failures of the chat service are not rejections
+	if code, _ := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "limit@example.org"}); code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", code)
+	}
+
+	// Forms are accepted too
+	r := httptest.NewRequest("POST", "/api/invite", strings.NewReader(url.Values{"email": {"bob@example.org"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(c, InviteHandler, r); w.Code != http.StatusOK {
+		t.Errorf("expected status 200 for a form, got %d %s", w.Code, w.Body.String())
+	}
+
+	c.settings.Invite.Enabled = false
+	if code, _ := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "carol@example.org"}); code != http.StatusNotFound {
+		t.Errorf("expected status 404 when closed, got %d", code)
+	}
+}
+
+func TestInviteRateLimit(t *testing.T) {
+	c, _, cleanup := newInviteContext(t)
+	defer cleanup()
+	c.settings.Invite.RateLimit = 2
+
+	for i := 0; i < 2; i++ {
+		if code, _ := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "not an address"}); code != http.StatusUnprocessableEntity {
+			t.Fatalf("attempt %d: expected status 422, got %d", i, code)
+		}
+	}
+	r := httptest.NewRequest("POST", "/api/invite", strings.NewReader(`{"email": "alice@example.org"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "192.0.2.1:4321"
+	w := serve(c, InviteHandler, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected status 429 with Retry-After, got %d", w.Code)
+	}
+	if code, _ := postInvite(t, c, "192.0.2.2:1234", inviteRequest{Email: "alice@example.org"}); code != http.StatusOK {
+		t.Errorf("expected other clients to be invited, got %d", code)
+	}
+}
+
+func TestInviteRateLimitBehindProxy(t *testing.T) {
+	c, _, cleanup := newInviteContext(t)
+	defer cleanup()
+	c.settings.Invite.RateLimit = 2
+	c.settings.Server.TrustProxy = true
+	c.settings.Server.TrustedProxy = []string{"10.0.0.1"}
+
+	// The client can prepend anything, the proxy appends its address
+	post := func(forwarded string) int {
+		r := httptest.NewRequest("POST", "/api/invite", strings.NewReader(`{"email": "not an address"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Forwarded-For", forwarded)
+		r.RemoteAddr = "10.0.0.1:1234"
+		return serve(c, InviteHandler, r).Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := post("198.51.100." + strconv.Itoa(i) + ", 192.0.2.1"); code != http.StatusUnprocessableEntity {
+			t.Fatalf("attempt %d: expected status 422, got %d", i, code)
+		}
+	}
+	if code := post("198.51.100.99, 192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a spoofed X-Forwarded-For not to reset the limit, got %d", code)
+	}
+	if code := post("192.0.2.2"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected other clients behind the proxy to be served, got %d", code)
+	}
+}
+
+func TestInviteProofOfWork(t *testing.T) {
+	c, _, cleanup := newInviteContext(t)
+	defer cleanup()
+	c.settings.Invite.ProofOfWork = 8
+
+	w := serve(c, InviteInfoHandler, httptest.NewRequest("GET", "/api/invite", nil))
+	var info inviteInfoData
+	json.Unmarshal(w.Body.Bytes(), &info)
+	if !info.Enabled || info.ProofOfWork == nil || info.ProofOfWork.Difficulty != 8 {
+		t.Fatalf("expected a challenge, got %s", w.Body.String())
+	}
+
+	if _, data := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "alice@example.org"}); data.Reason != reasonInvalidProof {
+		t.Errorf("expected a missing proof to be rejected, got %+v", data)
+	}
+
+	challenge := info.ProofOfWork.Challenge
+	nonce := ""
+	for i := 0; ; i++ {
+		nonce = strconv.Itoa(i)
+		if invite.LeadingZeros(sha256.Sum256([]byte(challenge+":"+nonce))) >= 8 {
+			break
+		}
+	}
+	request := inviteRequest{Email: "alice@example.org", Challenge: challenge, Nonce: nonce}
+	if code, data := postInvite(t, c, "192.0.2.1:1234", request); code != http.StatusOK || data.Status != inviteInvited {
+		t.Errorf("expected the proof to be accepted, got %d %+v", code, data)
+	}
+	if _, data := postInvite(t, c, "192.0.2.1:1234", request); data.Reason != reasonInvalidProof {
+		t.Errorf("expected a proof to be used once, got %+v", data)
+	}
+}
+
+func TestInviteMatrix(t *testing.T) {
+	var sent map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/_matrix/client/r0/rooms/%21liri:example.org/invite" || r.Header.Get("Authorization") != "Bearer syt-test" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errcode": "M_FORBIDDEN", "error": "not allowed"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sent)
+		if sent["address"] == "denied@example.org" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errcode": "M_THREEPID_DENIED", "error": "third party identifier is not allowed"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	c := newTestContext("")
+	c.settings.Team.Provider = "matrix"
+	c.settings.Matrix.Homeserver = ts.URL
+	c.settings.Matrix.Token = "syt-test"
+	c.settings.Matrix.Room = "!liri:example.org"
+	c.settings.Invite.Enabled = true
+
+	if code, _ := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "alice@example.org"}); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 without an identity server, got %d", code)
+	}
+
+	c.settings.Matrix.IdentityServer = "https://vector.im"
+	c.settings.Matrix.IdentityToken = "identity-token"
+	code, data := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "alice@example.org"})
+	if code != http.StatusOK || data.Status != inviteInvited {
+		t.Fatalf("expected alice to be invited, got %d %+v", code, data)
+	}
+	if sent["medium"] != "email" || sent["address"] != "alice@example.org" || sent["id_server"] != "vector.im" || sent["id_access_token"] != "identity-token" {
+		t.Errorf("unexpected invitation %v", sent)
+	}
+
+	if _, data := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "denied@example.org"}); data.Status != inviteRejected {
+		t.Errorf("expected a refused address to be rejected, got %+v", data)
+	}
+
+	// The visitor is not blamed for our credentials
+	c.settings.Matrix.Token = "syt-wrong"
+	if code, data := postInvite(t, c, "192.0.2.1:1234", inviteRequest{Email: "alice@example.org"}); code != http.StatusBadGateway {
+		t.Errorf("expected status 502 with a wrong token, got %d %+v", code, data)
+	}
+}
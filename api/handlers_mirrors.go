@@ -0,0 +1,43 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/http"
+
+	mirrors "github.com/lirios/website/mirrors"
+)
+
+// mirrorStatusData is the response of the mirror status API.
+type mirrorStatusData struct {
+	Ok      bool              `json:"ok"`
+	Mirrors []*mirrors.Status `json:"mirrors"`
+}
+
+// MirrorsStatusHandler is a http handler for the status of download mirrors.
+func MirrorsStatusHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	return http.StatusOK, mirrorStatusData{Ok: true, Mirrors: c.Mirrors().Status()}, nil
+}
@@ -0,0 +1,141 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	releases "github.com/lirios/website/releases"
+	server "github.com/lirios/website/server"
+)
+
+// releaseListData is the response of the releases list API.
+type releaseListData struct {
+	Ok       bool                `json:"ok"`
+	Releases []*releases.Release `json:"releases"`
+}
+
+// latestReleasesData is the response of the latest releases API.
+type latestReleasesData struct {
+	Ok       bool                         `json:"ok"`
+	Releases map[string]*releases.Release `json:"releases"`
+}
+
+// releaseData is the response of the single release APIs.
+type releaseData struct {
+	Ok      bool              `json:"ok"`
+	Release *releases.Release `json:"release"`
+}
+
+// FetchReleases loads the release catalog from the configured manifest
+// or directory, ready to be cached.
+func FetchReleases(c Context) (interface{}, error) {
+	settings := c.Settings().Releases
+	switch {
+	case settings.Manifest != "":
+		return releases.LoadManifest(settings.Manifest, settings.BaseURL)
+	case settings.Directory != "":
+		return releases.LoadDirectory(settings.Directory, settings.BaseURL)
+	}
+	// Sites that don't publish releases have none to serve
+	return nil, server.NotFound("no releases are published")
+}
+
+// releaseCatalog returns the cached release catalog.
+func releaseCatalog(c Context) (*releases.Catalog, error) {
+	cached, err := c.ReleasesCache().Get()
+	if err != nil {
+		return nil, err
+	}
+	return cached.(*releases.Catalog), nil
+}
+
+// ReleasesHandler is a http handler listing all releases, optionally
+// only those of the channel passed with the channel query parameter.
+func ReleasesHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	catalog, err := releaseCatalog(c)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result := releaseListData{Ok: true, Releases: catalog.Releases}
+	if channel := r.URL.Query().Get("channel"); channel != "" {
+		result.Releases = catalog.Channel(channel)
+	}
+	if result.Releases == nil {
+		result.Releases = []*releases.Release{}
+	}
+	return http.StatusOK, result, nil
+}
+
+// LatestReleasesHandler is a http handler for the latest release of each channel.
+func LatestReleasesHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	catalog, err := releaseCatalog(c)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result := latestReleasesData{Ok: true, Releases: make(map[string]*releases.Release)}
+	for _, channel := range catalog.Channels() {
+		result.Releases[channel] = catalog.Latest(channel)
+	}
+	return http.StatusOK, result, nil
+}
+
+// LatestReleaseHandler is a http handler for the latest release of a channel.
+func LatestReleaseHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	catalog, err := releaseCatalog(c)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	release := catalog.Latest(mux.Vars(r)["channel"])
+	if release == nil {
+		return 0, nil, server.NotFound("no release on this channel")
+	}
+	return http.StatusOK, releaseData{Ok: true, Release: release}, nil
+}
+
+// ReleaseHandler is a http handler for a single release.
+func ReleaseHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	catalog, err := releaseCatalog(c)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	release := catalog.Find(mux.Vars(r)["version"])
+	if release == nil {
+		return 0, nil, server.NotFound("release not found")
+	}
+	return http.StatusOK, releaseData{Ok: true, Release: release}, nil
+}
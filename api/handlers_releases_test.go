@@ -0,0 +1,160 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testManifest is a release catalog with two channels.
+const testManifest = `{"releases": [
+	{"version": "0.9.0", "channel": "stable", "date": "2017-06-01T00:00:00Z",
+	 "files": [{"name": "liri-0.9.0.iso", "size": 1024}]},
+	{"version": "0.10.0-rc1", "channel": "unstable", "date": "2017-07-01T00:00:00Z",
+	 "files": [{"name": "liri-0.10.0-rc1.iso", "size": 1024}]},
+	{"version": "0.8.0", "channel": "stable", "date": "2017-01-01T00:00:00Z",
+	 "files": [{"name": "liri-0.8.0.iso", "size": 1024}]}
+]}`
+
+// newReleasesContext returns a context publishing testManifest
+// and a function removing it.
+func newReleasesContext(t *testing.T) (*testContext, func()) {
+	dir, err := ioutil.TempDir("", "releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "releases.json")
+	if err := ioutil.WriteFile(manifest, []byte(testManifest), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	c := newTestContext("")
+	c.settings.Releases.Manifest = manifest
+	c.settings.Releases.BaseURL = "https://download.example.org"
+	return c, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReleasesHandler(t *testing.T) {
+	c, cleanup := newReleasesContext(t)
+	defer cleanup()
+
+	tests := []struct {
+		target   string
+		versions []string
+	}{
+		{"/api/releases", []string{"0.10.0-rc1", "0.9.0", "0.8.0"}},
+		{"/api/releases?channel=stable", []string{"0.9.0", "0.8.0"}},
+		{"/api/releases?channel=nightly", []string{}},
+	}
+	for _, test := range tests {
+		w := serveRoute(c, "/api/releases", ReleasesHandler, httptest.NewRequest("GET", test.target, nil))
+		var data releaseListData
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("%s: %v", test.target, err)
+		}
+		versions := []string{}
+		for _, release := range data.Releases {
+			versions = append(versions, release.Version)
+		}
+		if w.Code != http.StatusOK || !data.Ok || len(versions) != len(test.versions) {
+			t.Errorf("%s: expected %v, got %d %v", test.target, test.versions, w.Code, versions)
+			continue
+		}
+		for i := range versions {
+			if versions[i] != test.versions[i] {
+				t.Errorf("%s: expected %v, got %v", test.target, test.versions, versions)
+				break
+			}
+		}
+	}
+}
+
+func TestLatestReleasesHandler(t *testing.T) {
+	c, cleanup := newReleasesContext(t)
+	defer cleanup()
+
+	w := serveRoute(c, "/api/releases/latest", LatestReleasesHandler, httptest.NewRequest("GET", "/api/releases/latest", nil))
+	var data latestReleasesData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Releases) != 2 || data.Releases["stable"].Version != "0.9.0" || data.Releases["unstable"].Version != "0.10.0-rc1" {
+		t.Errorf("unexpected latest releases %s", w.Body.String())
+	}
+}
+
+func TestReleaseHandlers(t *testing.T) {
+	c, cleanup := newReleasesContext(t)
+	defer cleanup()
+
+	tests := []struct {
+		pattern string
+		handler HandlerFunc
+		target  string
+		code    int
+		version string
+	}{
+		{"/api/releases/latest/{channel}", LatestReleaseHandler, "/api/releases/latest/stable", http.StatusOK, "0.9.0"},
+		{"/api/releases/latest/{channel}", LatestReleaseHandler, "/api/releases/latest/nightly", http.StatusNotFound, ""},
+		{"/api/releases/{version}", ReleaseHandler, "/api/releases/0.8.0", http.StatusOK, "0.8.0"},
+		{"/api/releases/{version}", ReleaseHandler, "/api/releases/1.0.0", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serveRoute(c, test.pattern, test.handler, httptest.NewRequest("GET", test.target, nil))
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.target, test.code, w.Code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var data releaseData
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatalf("%s: %v", test.target, err)
+		}
+		if data.Release.Version != test.version {
+			t.Errorf("%s: expected release %s, got %s", test.target, test.version, data.Release.Version)
+		}
+		if url := data.Release.Files[0].URL; url != "https://download.example.org/"+data.Release.Channel+"/"+test.version+"/"+data.Release.Files[0].Name {
+			t.Errorf("%s: unexpected file url %q", test.target, url)
+		}
+	}
+}
+
+func TestReleasesHandlerNotConfigured(t *testing.T) {
+	c := newTestContext("")
+
+	w := serveRoute(c, "/api/releases", ReleasesHandler, httptest.NewRequest("GET", "/api/releases", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without releases, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -25,55 +25,70 @@
 package api
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"net/url"
-	"sort"
 	"strings"
+	"time"
 
+	avatars "github.com/lirios/website/avatars"
 	server "github.com/lirios/website/server"
 )
 
-// member represents a Slack team member.
-type member struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	RealName string `json:"real_name"`
-	TzLabel  string `json:"tz_label"`
-	Tz       string `json:"tz"`
-	TzOffset int    `json:"tz_offset"`
-	Profile  struct {
-		Image24   string `json:"image_24"`
-		Image32   string `json:"image_32"`
-		Image48   string `json:"image_48"`
-		Image72   string `json:"image_72"`
-		Image192  string `json:"image_192"`
-		Image512  string `json:"image_512"`
-		Image1024 string `json:"image_1024"`
-	} `json:"profile"`
-	IsBot    bool   `json:"is_bot"`
-	IsAdmin  bool   `json:"is_admin"`
-	Deleted  bool   `json:"deleted"`
-	Presence string `json:"presence,omitempty"`
-}
+// Roles of the members, from the most to the least privileged.
+const (
+	roleOwner  = "owner"
+	roleAdmin  = "admin"
+	roleMember = "member"
+	roleGuest  = "guest"
+)
 
-// members is a list of Slack team members.
-type members []member
+// memberRoles lists the roles in order of privilege.
+var memberRoles = server.MemberRoles
 
-// userListData is the content of Slack team members response.
-type userListData struct {
-	Ok      bool    `json:"ok"`
-	Members members `json:"members"`
+// roleRank returns the position of a role in memberRoles.
+func roleRank(role string) int {
+	for i, r := range memberRoles {
+		if r == role {
+			return i
+		}
+	}
+	return len(memberRoles)
 }
 
 // filteredMember is a Member with filtered out information.
 type filteredMember struct {
+	ID       string `json:"-"`
 	Name     string `json:"name"`
 	RealName string `json:"real_name"`
 	Tz       string `json:"tz"`
 	Image    string `json:"image"`
 	Presence string `json:"presence,omitempty"`
+	Role     string `json:"role"`
+	IsAdmin  bool   `json:"is_admin"`
+	IsOwner  bool   `json:"is_owner"`
+	Title    string `json:"title,omitempty"`
+	GitHub   string `json:"github,omitempty"`
+	Mastodon string `json:"mastodon,omitempty"`
+	Website  string `json:"website,omitempty"`
+	TzOffset int    `json:"-"`
+	// Joined is when the member joined, if known.
+	Joined time.Time `json:"-"`
+	// Avatars are the copies of the image the avatar proxy picks from.
+	Avatars avatars.Sources `json:"-"`
+}
+
+// setRole sets the role of the member, owners being administrators too.
+func (m *filteredMember) setRole(role string) {
+	m.Role = role
+	m.IsOwner = role == roleOwner
+	m.IsAdmin = role == roleOwner || role == roleAdmin
+}
+
+// displayName returns the name members are known by.
+func (m *filteredMember) displayName() string {
+	if m.RealName != "" {
+		return m.RealName
+	}
+	return m.Name
 }
 
 // filteredMembers is a list of filtered out members.
@@ -82,84 +97,46 @@ type filteredMembers []filteredMember
 // filteredUserListData is the response of our API service.
 type filteredUserListData struct {
 	Ok      bool            `json:"ok"`
+	Total   int             `json:"total"`
 	Members filteredMembers `json:"members"`
 }
 
-// Len returns the length of the slice.
-func (slice members) Len() int {
-	return len(slice)
-}
-
-// Less compares two slice items and returns true if index i should go before index j.
-func (slice members) Less(i, j int) bool {
-	return slice[i].IsAdmin && !slice[j].IsAdmin
-}
-
-// Swap swaps two slice items.
-func (slice members) Swap(i, j int) {
-	slice[i], slice[j] = slice[j], slice[i]
-}
-
-// Read text from URL.
-func textFromURL(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(body), nil
-}
-
-// TeamHandler is a http handler for the team API.
-func TeamHandler(c server.Context, w http.ResponseWriter, r *http.Request) (int, []byte) {
-	w.Header().Set("Content-Type", "application/json")
-
+// allowLocalOrigin allows cross-origin requests when developing locally.
+func allowLocalOrigin(w http.ResponseWriter, r *http.Request) {
 	// For easier debugging - JavaScript won't accept json from another domain otherwise
 	if strings.Contains(r.Host, "localhost") || strings.Contains(r.Host, "127.0.0.1") {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 	}
+}
 
-	resp, err := textFromURL("https://slack.com/api/users.list?presence=1&token=" + c.Settings().Slack.Token)
+// TeamHandler is a http handler for the team API.
+func TeamHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	query, err := parseTeamQuery(r.URL.Query(), c.Settings().Team.Sort)
 	if err != nil {
-		return http.StatusBadRequest, []byte(err.Error())
+		return 0, nil, err
 	}
 
-	// Parse to go object (all unnecessary info is ignored)
-	data := userListData{}
-	err = json.Unmarshal([]byte(resp), &data)
+	// Members are cached to avoid hitting the chat service rate limits
+	cached, err := c.TeamCache().Get()
 	if err != nil {
-		return http.StatusBadRequest, []byte(err.Error())
+		return 0, nil, upstreamError(w, err)
 	}
 
-	// Put administrators first
-	sort.Sort(data.Members)
-
-	// Parse the object back to json and print it
-	result := filteredUserListData{Ok: data.Ok}
-	for _, v := range data.Members {
-		// Exclude deleted members, bots and filter out some information
-		if v.ID != "USLACKBOT" && !v.IsBot && !v.Deleted {
-			member := filteredMember{}
-			member.Name = v.Name
-			member.RealName = v.RealName
-			member.Tz = v.Tz
-			u, err := url.QueryUnescape(v.Profile.Image512)
-			if err == nil {
-				member.Image = u
-			} else {
-				member.Image = v.Profile.Image512
-			}
-			member.Presence = v.Presence
-			result.Members = append(result.Members, member)
-		}
-	}
-	finalJSON, err := json.Marshal(result)
-	if err != nil {
-		return http.StatusBadRequest, []byte(err.Error())
+	// The privacy policy is applied before filtering, so that the
+	// filters can't reveal what is not published
+	members, total := query.apply(publish(cached.(filteredMembers), c.Settings()))
+	return http.StatusOK, filteredUserListData{Ok: true, Total: total, Members: members}, nil
+}
+
+// publish returns the members as published: curated, following the
+// privacy policy and with their images served by the avatar proxy.
+func publish(members filteredMembers, settings *server.Settings) filteredMembers {
+	policy := privacyPolicy{settings}
+	result := policy.apply(curated(members, settings))
+	for i := range result {
+		result[i].Image = avatarURL(result[i], settings.Avatars.BaseURL)
 	}
-	return http.StatusOK, finalJSON
+	return result
 }
@@ -0,0 +1,118 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+
+	server "github.com/lirios/website/server"
+)
+
+// auditEntry tells what is published about a member.
+type auditEntry struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Published bool     `json:"published"`
+	Reason    string   `json:"reason,omitempty"`
+	Fields    []string `json:"fields"`
+}
+
+// auditData is the response of the team audit API.
+type auditData struct {
+	Ok      bool         `json:"ok"`
+	Policy  string       `json:"policy"`
+	Members []auditEntry `json:"members"`
+}
+
+// authorizeAudit checks the bearer token of a request to the audit API.
+func authorizeAudit(c Context, w http.ResponseWriter, r *http.Request) error {
+	expected := c.Settings().Privacy.AuditToken
+	if expected == "" {
+		return server.NotFound("the audit is disabled")
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="audit"`)
+		return server.Unauthorized("a valid audit token is required")
+	}
+	return nil
+}
+
+// TeamAuditHandler lists, for the maintainers, which fields the team
+// API publishes about each member, and why some members are not published.
+func TeamAuditHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	if err := authorizeAudit(c, w, r); err != nil {
+		return 0, nil, err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	cached, err := c.TeamCache().Get()
+	if err != nil {
+		return 0, nil, upstreamError(w, err)
+	}
+	settings := c.Settings()
+	policy := privacyPolicy{settings}
+
+	members := filteredMembers{}
+	var entries []auditEntry
+	for _, m := range cached.(filteredMembers) {
+		if o := settings.Member[m.ID]; o != nil && o.Hide {
+			entries = append(entries, auditEntry{ID: m.ID, Name: m.Name, Reason: reasonHidden, Fields: []string{}})
+			continue
+		}
+		members = append(members, m)
+	}
+	for _, m := range curated(members, settings) {
+		entry := auditEntry{ID: m.ID, Name: m.Name, Fields: []string{}}
+		entry.Published, entry.Reason = policy.published(m)
+		if entry.Published {
+			entry.Fields = publishedFields(policy.minimize(m))
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(auditEntries(entries))
+
+	return http.StatusOK, auditData{Ok: true, Policy: settings.Privacy.Policy, Members: entries}, nil
+}
+
+// auditEntries sorts the audit by member identifier.
+type auditEntries []auditEntry
+
+// Len returns the length of the slice.
+func (slice auditEntries) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice auditEntries) Less(i, j int) bool {
+	return slice[i].ID < slice[j].ID
+}
+
+// Swap swaps two slice items.
+func (slice auditEntries) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
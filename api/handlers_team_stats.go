@@ -0,0 +1,123 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	badge "github.com/lirios/website/badge"
+	server "github.com/lirios/website/server"
+)
+
+// Cache lifetimes of the badge, shorter when the team is not available.
+const (
+	badgeMaxAge      = 5 * 60
+	badgeErrorMaxAge = 60
+)
+
+// teamStatsData is the response of the team stats API. Online members
+// are not counted when presence is not published.
+type teamStatsData struct {
+	Ok        bool           `json:"ok"`
+	Members   int            `json:"members"`
+	Online    *int           `json:"online,omitempty"`
+	Admins    int            `json:"admins"`
+	Timezones int            `json:"timezones"`
+	Regions   map[string]int `json:"regions"`
+}
+
+// teamStats counts the published members, the timezones they are in
+// and the regions of the world, such as Europe for Europe/Rome.
+func teamStats(members filteredMembers, settings *server.Settings) teamStatsData {
+	stats := teamStatsData{Ok: true, Members: len(members), Regions: map[string]int{}}
+	online := 0
+	timezones := map[string]bool{}
+	for _, m := range members {
+		if m.Presence == "active" {
+			online++
+		}
+		if m.IsAdmin {
+			stats.Admins++
+		}
+		if m.Tz == "" {
+			continue
+		}
+		timezones[m.Tz] = true
+		// Offsets published in place of timezones have no region
+		if i := strings.Index(m.Tz, "/"); i > 0 {
+			stats.Regions[m.Tz[:i]]++
+		}
+	}
+	stats.Timezones = len(timezones)
+	if settings.Privacy.Presence != server.Hide {
+		stats.Online = &online
+	}
+	return stats
+}
+
+// TeamStatsHandler is a http handler for the team stats API.
+func TeamStatsHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	cached, err := c.TeamCache().Get()
+	if err != nil {
+		return 0, nil, upstreamError(w, err)
+	}
+	return http.StatusOK, teamStats(publish(cached.(filteredMembers), c.Settings()), c.Settings()), nil
+}
+
+// ChatBadgeHandler is a http handler rendering a badge with the
+// number of members online, to be embedded in READMEs. The label
+// can be changed with ?label=.
+func ChatBadgeHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	b := badge.Badge{Label: r.URL.Query().Get("label")}
+	if b.Label == "" {
+		b.Label = "chat"
+	}
+
+	// Badges are shown even when the team is not available
+	maxAge := badgeMaxAge
+	cached, err := c.TeamCache().Get()
+	if err != nil {
+		log.Printf("Failed to get the team for the badge: %v", err)
+		b.Message, b.Color = "unavailable", "lightgrey"
+		maxAge = badgeErrorMaxAge
+	} else {
+		stats := teamStats(publish(cached.(filteredMembers), c.Settings()), c.Settings())
+		b.Message, b.Color = strconv.Itoa(stats.Members)+" members", "blue"
+		if stats.Online != nil {
+			b.Message = strconv.Itoa(*stats.Online) + "/" + strconv.Itoa(stats.Members) + " online"
+			if *stats.Online > 0 {
+				b.Color = "brightgreen"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	return http.StatusOK, b.SVG(), nil
+}
@@ -0,0 +1,101 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	server "github.com/lirios/website/server"
+)
+
+func TestTeamStatsHandler(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: testUsersList})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+
+	w := serve(c, TeamStatsHandler, httptest.NewRequest("GET", "/api/team/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var data teamStatsData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Members != 2 || data.Admins != 1 || data.Timezones != 1 {
+		t.Errorf("unexpected stats %+v", data)
+	}
+	if data.Online == nil || *data.Online != 1 {
+		t.Errorf("expected 1 member online, got %v", data.Online)
+	}
+	if !reflect.DeepEqual(data.Regions, map[string]int{"Europe": 1}) {
+		t.Errorf("unexpected regions %v", data.Regions)
+	}
+
+	// Presence can't be inferred when it is hidden
+	c.settings.Privacy.Presence = server.Hide
+	w = serve(c, TeamStatsHandler, httptest.NewRequest("GET", "/api/team/stats", nil))
+	if strings.Contains(w.Body.String(), `"online"`) {
+		t.Errorf("expected no online count, got %s", w.Body.String())
+	}
+}
+
+func TestChatBadgeHandler(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: testUsersList})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+
+	w := serve(c, ChatBadgeHandler, httptest.NewRequest("GET", "/badge/chat.svg?label=slack", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/svg+xml") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "1/2 online") || !strings.Contains(body, ">slack<") {
+		t.Errorf("unexpected badge %s", body)
+	}
+
+	c.settings.Privacy.Presence = server.Hide
+	w = serve(c, ChatBadgeHandler, httptest.NewRequest("GET", "/badge/chat.svg", nil))
+	if !strings.Contains(w.Body.String(), "2 members") {
+		t.Errorf("expected the members count only, got %s", w.Body.String())
+	}
+
+	// The badge is still rendered when Slack fails
+	failing := httptest.NewServer(&fakeSlack{body: `{"ok": false, "error": "invalid_auth"}`})
+	defer failing.Close()
+	w = serve(newTestContext(failing.URL), ChatBadgeHandler, httptest.NewRequest("GET", "/badge/chat.svg", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "unavailable") {
+		t.Errorf("expected an unavailable badge, got %d %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("unexpected cache control %q", cc)
+	}
+}
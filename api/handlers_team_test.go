@@ -0,0 +1,332 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	server "github.com/lirios/website/server"
+)
+
+// fakeSlack is a stand-in for the Slack Web API.
+type fakeSlack struct {
+	mu     sync.Mutex
+	calls  int
+	status int
+	body   string
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if r.URL.Path != "/users.list" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer xoxp-test" || r.URL.Query().Get("token") != "" {
+		w.Write([]byte(`{"ok": false, "error": "not_authed"}`))
+		return
+	}
+	if f.status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "12")
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(f.body))
+}
+
+const testUsersList = `{
+	"ok": true,
+	"members": [
+		{"id": "USLACKBOT", "name": "slackbot"},
+		{"id": "U1", "name": "alice", "real_name": "Alice", "tz": "Europe/Rome",
+		 "profile": {"image_512": "https://example.com/alice.png"}, "presence": "active"},
+		{"id": "U2", "name": "bob", "real_name": "Bob", "is_admin": true, "presence": "away", "tz_offset": -28800},
+		{"id": "U3", "name": "bot", "is_bot": true},
+		{"id": "U4", "name": "gone", "deleted": true}
+	]
+}`
+
+func getTeam(t *testing.T, c Context) (int, filteredUserListData) {
+	r := httptest.NewRequest("GET", "/api/team", nil)
+	w := serve(c, TeamHandler, r)
+	var data filteredUserListData
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, data
+}
+
+func TestTeamHandler(t *testing.T) {
+	slack := &fakeSlack{body: testUsersList}
+	ts := httptest.NewServer(slack)
+	defer ts.Close()
+
+	c := newTestContext(ts.URL)
+	code, data := getTeam(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(data.Members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(data.Members))
+	}
+	if data.Members[0].Name != "bob" {
+		t.Errorf("expected administrators first, got %q", data.Members[0].Name)
+	}
+	if !strings.HasPrefix(data.Members[1].Image, "/avatars/U1?v=") {
+		t.Errorf("unexpected image %q", data.Members[1].Image)
+	}
+
+	// Further requests are served from the cache
+	for i := 0; i < 5; i++ {
+		getTeam(t, c)
+	}
+	if slack.calls != 1 {
+		t.Errorf("expected 1 call to Slack, got %d", slack.calls)
+	}
+}
+
+func TestTeamHandlerSlackError(t *testing.T) {
+	tests := []struct {
+		status     int
+		body       string
+		code       int
+		id         string
+		retryAfter string
+	}{
+		{0, `{"ok": false, "error": "invalid_auth"}`, http.StatusBadGateway, "upstream_auth", ""},
+		{0, `{"ok": false, "error": "unknown_method"}`, http.StatusBadGateway, "upstream_error", ""},
+		{0, `{"ok": false, "error": "service_unavailable"}`, http.StatusServiceUnavailable, "upstream_unavailable", ""},
+		{http.StatusTooManyRequests, "", http.StatusServiceUnavailable, "upstream_rate_limited", "12"},
+		{http.StatusInternalServerError, "", http.StatusServiceUnavailable, "upstream_unavailable", ""},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(&fakeSlack{status: test.status, body: test.body})
+		w := serve(newTestContext(ts.URL), TeamHandler, httptest.NewRequest("GET", "/api/team", nil))
+		ts.Close()
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.id, test.code, w.Code)
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON error, got %q", test.id, w.Header().Get("Content-Type"))
+		}
+		if w.Header().Get("Retry-After") != test.retryAfter {
+			t.Errorf("%s: expected Retry-After %q, got %q", test.id, test.retryAfter, w.Header().Get("Retry-After"))
+		}
+		var data struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Ok || data.Error != test.id {
+			t.Errorf("unexpected error %+v, expected %s", data, test.id)
+		}
+	}
+}
+
+func TestTeamHandlerUnknownProvider(t *testing.T) {
+	c := newTestContext("http://127.0.0.1:0")
+	c.settings.Team.Provider = "carrier-pigeon"
+	code, _ := getTeam(t, c)
+	if code == http.StatusOK {
+		t.Fatal("expected an error for an unknown provider")
+	}
+}
+
+func TestTeamHandlerQuery(t *testing.T) {
+	slack := &fakeSlack{body: testUsersList}
+	ts := httptest.NewServer(slack)
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+
+	tests := []struct {
+		query string
+		total int
+		names []string
+	}{
+		{"", 2, []string{"bob", "alice"}},
+		{"?q=ALI", 1, []string{"alice"}},
+		{"?q=bob", 1, []string{"bob"}},
+		{"?presence=active", 1, []string{"alice"}},
+		{"?tz=europe/rome", 1, []string{"alice"}},
+		{"?role=admin", 1, []string{"bob"}},
+		{"?role=member", 1, []string{"alice"}},
+		{"?limit=1", 2, []string{"bob"}},
+		{"?limit=1&offset=1", 2, []string{"alice"}},
+		{"?offset=5", 2, []string{}},
+		{"?sort=name", 2, []string{"alice", "bob"}},
+		{"?sort=-name", 2, []string{"bob", "alice"}},
+		{"?sort=presence", 2, []string{"alice", "bob"}},
+		{"?sort=tz", 2, []string{"bob", "alice"}},
+		{"?sort=-role", 2, []string{"alice", "bob"}},
+		{"?sort=joined", 2, []string{"alice", "bob"}},
+	}
+	for _, test := range tests {
+		w := serve(c, TeamHandler, httptest.NewRequest("GET", "/api/team"+test.query, nil))
+		var data filteredUserListData
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Total != test.total {
+			t.Errorf("%q: expected a total of %d, got %d", test.query, test.total, data.Total)
+		}
+		var names []string
+		for _, m := range data.Members {
+			names = append(names, m.Name)
+		}
+		if len(names) != len(test.names) {
+			t.Errorf("%q: expected %v, got %v", test.query, test.names, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.names[i] {
+				t.Errorf("%q: expected %v, got %v", test.query, test.names, names)
+				break
+			}
+		}
+	}
+	if slack.calls != 1 {
+		t.Errorf("expected 1 call to Slack, got %d", slack.calls)
+	}
+
+	for _, query := range []string{"?limit=-1", "?offset=x", "?role=king", "?sort=age"} {
+		w := serve(c, TeamHandler, httptest.NewRequest("GET", "/api/team"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestMemberOrder(t *testing.T) {
+	for _, key := range server.TeamSortKeys {
+		if _, ok := memberComparers[key]; !ok {
+			t.Errorf("sort key %q is valid in the settings but not implemented", key)
+		}
+	}
+	if len(memberComparers) != len(server.TeamSortKeys) {
+		t.Errorf("expected %d sort keys, got %d", len(server.TeamSortKeys), len(memberComparers))
+	}
+
+	// Ties are broken by name, whatever the initial order
+	joined := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	members := filteredMembers{
+		{Name: "dave"},
+		{Name: "carol", Joined: joined.Add(time.Hour)},
+		{Name: "bob"},
+		{Name: "alice", Joined: joined},
+		{Name: "erin", Joined: joined},
+	}
+	order, err := parseMemberOrder("joined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order.sort(members)
+	expected := []string{"alice", "erin", "carol", "bob", "dave"}
+	for i, m := range members {
+		if m.Name != expected[i] {
+			t.Fatalf("expected %v, got %+v", expected, members)
+		}
+	}
+}
+
+func TestMemberOrderSameName(t *testing.T) {
+	// Names collide across Matrix servers, the order must not change
+	// from one poll to the next
+	order, err := parseMemberOrder("role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, members := range []filteredMembers{
+		{{ID: "@alice:b.org", Name: "alice"}, {ID: "@alice:a.org", Name: "alice"}, {ID: "@alice:c.org", Name: "alice"}},
+		{{ID: "@alice:c.org", Name: "alice"}, {ID: "@alice:a.org", Name: "alice"}, {ID: "@alice:b.org", Name: "alice"}},
+	} {
+		order.sort(members)
+		if members[0].ID != "@alice:a.org" || members[1].ID != "@alice:b.org" || members[2].ID != "@alice:c.org" {
+			t.Errorf("expected members sorted by ID, got %+v", members)
+		}
+	}
+}
+
+func TestTeamHandlerRoles(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: `{
+		"ok": true,
+		"members": [
+			{"id": "U1", "name": "guest", "is_restricted": true},
+			{"id": "U2", "name": "member"},
+			{"id": "U3", "name": "admin", "is_admin": true},
+			{"id": "U4", "name": "owner", "is_admin": true, "is_owner": true}
+		]
+	}`})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+	c.settings.Member = map[string]*server.MemberSettings{
+		"U4": {Title: "Project Lead"},
+	}
+
+	code, data := getTeam(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	expected := []struct {
+		role             string
+		isAdmin, isOwner bool
+		title            string
+	}{
+		{"owner", true, true, "Project Lead"},
+		{"admin", true, false, ""},
+		{"member", false, false, ""},
+		{"guest", false, false, ""},
+	}
+	if len(data.Members) != len(expected) {
+		t.Fatalf("expected %d members, got %d", len(expected), len(data.Members))
+	}
+	for i, e := range expected {
+		m := data.Members[i]
+		if m.Name != e.role || m.Role != e.role || m.IsAdmin != e.isAdmin || m.IsOwner != e.isOwner || m.Title != e.title {
+			t.Errorf("expected %+v, got %+v", e, m)
+		}
+	}
+
+	w := serve(c, TeamHandler, httptest.NewRequest("GET", "/api/team?role=owner,admin", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Total != 2 {
+		t.Errorf("expected 2 owners and administrators, got %d", data.Total)
+	}
+}
@@ -0,0 +1,114 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	server "github.com/lirios/website/server"
+	slack "github.com/lirios/website/slack"
+)
+
+// maxEventSize limits the size of the Events API requests.
+const maxEventSize = 1 << 20
+
+// SlackEventsHandler is a http handler receiving the requests of the
+// Slack Events API, which keep the cached team up to date as members
+// join or change their profile.
+func SlackEventsHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	settings := c.Settings()
+	secret := settings.Slack.SigningSecret
+	if secret == "" || (settings.Team.Provider != "" && settings.Team.Provider != "slack") {
+		return 0, nil, server.NotFound("not found")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxEventSize+1))
+	if err != nil {
+		return 0, nil, server.BadRequest("The request could not be read")
+	}
+	if len(body) > maxEventSize {
+		return 0, nil, server.NewError(http.StatusRequestEntityTooLarge, "too_large", "The request is too large")
+	}
+	if err := slack.VerifyRequest(secret, r.Header, body, time.Now()); err != nil {
+		return 0, nil, server.Unauthorized("The request signature is not valid")
+	}
+
+	var request slack.EventRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return 0, nil, server.BadRequest("The request is not valid JSON")
+	}
+	switch request.Type {
+	case slack.URLVerification:
+		return http.StatusOK, map[string]string{"challenge": request.Challenge}, nil
+	case slack.EventCallback:
+		event, err := request.ParseEvent()
+		if err != nil {
+			return 0, nil, server.BadRequest("The event is not valid")
+		}
+		switch event.Type {
+		case slack.TeamJoin, slack.UserChange, slack.UserProfileChanged:
+			if event.User != nil {
+				updateSlackMember(c.TeamCache(), *event.User)
+			}
+		}
+	}
+
+	// Slack only needs to know the event was received
+	return http.StatusOK, map[string]bool{"ok": true}, nil
+}
+
+// updateSlackMember replaces a member of the cached team with the
+// current information from Slack, adding or removing the member as
+// needed. Nothing changes if the team wasn't fetched yet, as it will
+// be up to date when it is.
+func updateSlackMember(cache *server.Cache, u slack.User) bool {
+	return cache.Update(func(v interface{}) interface{} {
+		members := v.(filteredMembers)
+		result := make(filteredMembers, 0, len(members)+1)
+		found := false
+		for _, m := range members {
+			if m.ID != u.ID {
+				result = append(result, m)
+				continue
+			}
+			found = true
+			if isSlackMember(u) {
+				updated := filteredSlackUser(u)
+				// Events don't tell the presence
+				if updated.Presence == "" {
+					updated.Presence = m.Presence
+				}
+				result = append(result, updated)
+			}
+		}
+		if !found && isSlackMember(u) {
+			result = append(result, filteredSlackUser(u))
+		}
+		return result
+	})
+}
@@ -0,0 +1,105 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	slack "github.com/lirios/website/slack"
+)
+
+const testSigningSecret = "signing-secret"
+
+// postSlackEvent sends a signed Events API request.
+func postSlackEvent(c *testContext, body string, sent time.Time) *httptest.ResponseRecorder {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	r := httptest.NewRequest("POST", "/hooks/slack/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", slack.Sign(testSigningSecret, timestamp, []byte(body)))
+	return serve(c, SlackEventsHandler, r)
+}
+
+func TestSlackEventsVerification(t *testing.T) {
+	c := newTestContext("")
+	body := `{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`
+	if w := postSlackEvent(c, body, time.Now()); w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without a signing secret, got %d", w.Code)
+	}
+
+	c.settings.Slack.SigningSecret = testSigningSecret
+	w := postSlackEvent(c, body, time.Now())
+	var data map[string]string
+	json.Unmarshal(w.Body.Bytes(), &data)
+	if w.Code != http.StatusOK || data["challenge"] != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("expected the challenge back, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := postSlackEvent(c, body, time.Now().Add(-10*time.Minute)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed requests to be rejected, got %d", w.Code)
+	}
+	r := httptest.NewRequest("POST", "/hooks/slack/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	r.Header.Set("X-Slack-Signature", "v0=forged")
+	if w := serve(c, SlackEventsHandler, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected forged requests to be rejected, got %d", w.Code)
+	}
+}
+
+func TestSlackEventsUpdateTeam(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: testUsersList})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+	c.settings.Slack.SigningSecret = testSigningSecret
+	getTeam(t, c)
+
+	events := []string{
+		`{"type": "team_join", "user": {"id": "U5", "name": "carol", "real_name": "Carol"}}`,
+		`{"type": "user_change", "user": {"id": "U1", "name": "alice", "real_name": "Alice Liddell", "is_admin": true}}`,
+		`{"type": "user_change", "user": {"id": "U2", "name": "bob", "deleted": true}}`,
+	}
+	for _, e := range events {
+		w := postSlackEvent(c, `{"type": "event_callback", "event": `+e+`}`, time.Now())
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	_, data := getTeam(t, c)
+	if len(data.Members) != 2 {
+		t.Fatalf("expected 2 members, got %+v", data.Members)
+	}
+	alice, carol := data.Members[0], data.Members[1]
+	if alice.RealName != "Alice Liddell" || !alice.IsAdmin || alice.Presence != "active" {
+		t.Errorf("expected alice to be updated keeping the presence, got %+v", alice)
+	}
+	if carol.Name != "carol" {
+		t.Errorf("expected carol to join, got %+v", carol)
+	}
+}
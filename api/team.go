@@ -0,0 +1,113 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	server "github.com/lirios/website/server"
+	slack "github.com/lirios/website/slack"
+)
+
+// TeamProvider retrieves the community members from a chat service.
+//
+// Implementations return every member that may appear on the team page,
+// leaving ordering and caching to the caller.
+type TeamProvider interface {
+	Members(c Context) (filteredMembers, error)
+}
+
+// teamProviders maps the names accepted by the provider setting
+// to their implementation.
+var teamProviders = map[string]TeamProvider{
+	"slack":  slackProvider{},
+	"matrix": matrixProvider{},
+}
+
+// teamProvider returns the provider selected in the configuration.
+func teamProvider(c Context) (TeamProvider, error) {
+	name := c.Settings().Team.Provider
+	if name == "" {
+		name = server.DefaultTeamProvider
+	}
+	provider, ok := teamProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown team provider %q", name)
+	}
+	return provider, nil
+}
+
+// FetchTeam retrieves the team members from the configured provider,
+// ready to be cached.
+func FetchTeam(c Context) (interface{}, error) {
+	provider, err := teamProvider(c)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Members(c)
+}
+
+// upstreamError describes a failure of the chat service to the clients:
+// the service being down or rate limiting us is reported as temporary,
+// asking to retry later, anything else as a bad gateway.
+func upstreamError(w http.ResponseWriter, err error) *server.Error {
+	switch e := err.(type) {
+	case *slack.RateLimitError:
+		seconds := int(e.Delay.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		return server.NewError(http.StatusServiceUnavailable, "upstream_rate_limited",
+			"The chat service is rate limiting requests, try again later").
+			WithDetails(map[string]int{"retry_after": seconds})
+	case *slack.StatusError:
+		if e.StatusCode >= 500 {
+			return server.NewError(http.StatusServiceUnavailable, "upstream_unavailable",
+				"The chat service is unavailable, try again later")
+		}
+	case *slack.Error:
+		details := map[string]string{"slack_error": e.Code}
+		switch {
+		case e.IsAuth():
+			return server.NewError(http.StatusBadGateway, "upstream_auth",
+				"The chat service rejected our credentials").WithDetails(details)
+		case e.IsTemporary():
+			return server.NewError(http.StatusServiceUnavailable, "upstream_unavailable",
+				"The chat service is unavailable, try again later").WithDetails(details)
+		}
+		return server.UpstreamError(err).WithDetails(details)
+	case *matrixError:
+		details := map[string]string{"matrix_error": e.Code}
+		switch {
+		case e.StatusCode == http.StatusUnauthorized:
+			return server.NewError(http.StatusBadGateway, "upstream_auth",
+				"The chat service rejected our credentials").WithDetails(details)
+		case e.StatusCode >= 500:
+			return server.NewError(http.StatusServiceUnavailable, "upstream_unavailable",
+				"The chat service is unavailable, try again later").WithDetails(details)
+		}
+		return server.UpstreamError(err).WithDetails(details)
+	}
+	return server.UpstreamError(err)
+}
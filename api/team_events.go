@@ -0,0 +1,204 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	events "github.com/lirios/website/events"
+	server "github.com/lirios/website/server"
+)
+
+// Types of the team events.
+const (
+	eventJoin     = "join"
+	eventLeave    = "leave"
+	eventUpdate   = "update"
+	eventPresence = "presence"
+	// eventReset tells clients that missed events to fetch the team again.
+	eventReset = "reset"
+)
+
+// heartbeatInterval is how often a comment is sent to idle clients,
+// so that proxies don't close the connection.
+const heartbeatInterval = 20 * time.Second
+
+// reconnectDelay is how long browsers wait before reconnecting.
+const reconnectDelay = 5 * time.Second
+
+// teamEvent is a change to the published team.
+type teamEvent struct {
+	Type string
+	Data interface{}
+}
+
+// presenceData is the data of a presence event.
+type presenceData struct {
+	Name     string `json:"name"`
+	Presence string `json:"presence"`
+}
+
+// leaveData is the data of a leave event.
+type leaveData struct {
+	Name string `json:"name"`
+}
+
+// teamChanges returns the events turning the old team into the new one:
+// members who joined, updated, changed presence and left.
+func teamChanges(old, new filteredMembers) []teamEvent {
+	previous := make(map[string]filteredMember, len(old))
+	for _, m := range old {
+		previous[m.ID] = m
+	}
+
+	var result []teamEvent
+	current := make(map[string]bool, len(new))
+	for _, m := range new {
+		current[m.ID] = true
+		p, ok := previous[m.ID]
+		if !ok {
+			result = append(result, teamEvent{eventJoin, m})
+			continue
+		}
+		if m.Presence != p.Presence {
+			result = append(result, teamEvent{eventPresence, presenceData{m.Name, m.Presence}})
+		}
+		a, b := m, p
+		a.Presence, b.Presence = "", ""
+		if !reflect.DeepEqual(a, b) {
+			result = append(result, teamEvent{eventUpdate, m})
+		}
+	}
+	for _, m := range old {
+		if !current[m.ID] {
+			result = append(result, teamEvent{eventLeave, leaveData{m.Name}})
+		}
+	}
+	return result
+}
+
+// PublishTeamChanges publishes the changes to the published team
+// between two values of the team cache.
+func PublishTeamChanges(c Context, old, new interface{}) {
+	// The first fetch changes nothing clients know about
+	if old == nil || new == nil {
+		return
+	}
+	settings := c.Settings()
+	changes := teamChanges(publish(old.(filteredMembers), settings), publish(new.(filteredMembers), settings))
+	for _, e := range changes {
+		c.TeamEvents().Publish(e.Type, e.Data)
+	}
+}
+
+// WatchTeam refreshes the team while clients are listening to the team
+// events, polling the chat service once for all of them, until stop is
+// closed. Changes are published as the team cache is updated.
+func WatchTeam(c Context, stop <-chan struct{}) {
+	for {
+		interval := c.Settings().Team.PollInterval.OrDefault(server.DefaultTeamPollInterval)
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+
+		if c.TeamEvents().Subscribers() == 0 {
+			continue
+		}
+		if _, err := c.TeamCache().Refresh(); err != nil {
+			log.Printf("Failed to refresh the team: %v", err)
+		}
+	}
+}
+
+// TeamEventsHandler is a http handler streaming the changes to the team
+// as server-sent events. Clients reconnecting with Last-Event-ID get the
+// events they missed, or a reset event when they are not known anymore.
+func TeamEventsHandler(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	allowLocalOrigin(w, r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return 0, nil, server.NewError(http.StatusInternalServerError, "streaming_unsupported", "Streaming is not supported")
+	}
+	subscription, err := c.TeamEvents().Subscribe(r.Header.Get("Last-Event-ID"))
+	switch err {
+	case nil:
+	case events.ErrTooManySubscribers:
+		w.Header().Set("Retry-After", strconv.Itoa(int(reconnectDelay.Seconds())))
+		return 0, nil, server.NewError(http.StatusServiceUnavailable, "too_many_subscribers",
+			"Too many clients are listening, try again later")
+	default:
+		return 0, nil, server.Unavailable("Team events are not available")
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Don't let nginx buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	pending := []events.Event{}
+	if subscription.Missed {
+		pending = append(pending, events.Event{Type: eventReset, Data: []byte("{}")})
+	}
+	pending = append(pending, subscription.Replay...)
+	if _, err := w.Write([]byte("retry: " + strconv.Itoa(int(reconnectDelay/time.Millisecond)) + "\n\n")); err != nil {
+		return server.StatusWritten, nil, nil
+	}
+	for _, e := range pending {
+		if _, err := e.WriteTo(w); err != nil {
+			return server.StatusWritten, nil, nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case e, ok := <-subscription.Events():
+			if !ok {
+				// Too slow or shutting down, the client reconnects
+				return server.StatusWritten, nil, nil
+			}
+			_, err = e.WriteTo(w)
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": heartbeat\n\n"))
+		case <-r.Context().Done():
+			return server.StatusWritten, nil, nil
+		}
+		if err != nil {
+			return server.StatusWritten, nil, nil
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,175 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	server "github.com/lirios/website/server"
+)
+
+func TestTeamChanges(t *testing.T) {
+	old := filteredMembers{
+		{ID: "U1", Name: "alice", Presence: "away"},
+		{ID: "U2", Name: "bob", Presence: "away"},
+		{ID: "U3", Name: "carol"},
+	}
+	new := filteredMembers{
+		{ID: "U1", Name: "alice", Presence: "active"},
+		{ID: "U2", Name: "robert", Presence: "away"},
+		{ID: "U4", Name: "dave"},
+	}
+	changes := teamChanges(old, new)
+	expected := []string{eventPresence, eventUpdate, eventJoin, eventLeave}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, e := range expected {
+		if changes[i].Type != e {
+			t.Errorf("change %d: expected %s, got %+v", i, e, changes[i])
+		}
+	}
+	if p := changes[0].Data.(presenceData); p.Name != "alice" || p.Presence != "active" {
+		t.Errorf("unexpected presence change %+v", p)
+	}
+	if l := changes[3].Data.(leaveData); l.Name != "carol" {
+		t.Errorf("unexpected leave %+v", l)
+	}
+	if len(teamChanges(new, new)) != 0 {
+		t.Errorf("expected no changes")
+	}
+}
+
+// streamEvents connects to the team events and returns
+// a function reading the next event or comment.
+func streamEvents(t *testing.T, url, lastID string) (*http.Response, func() string) {
+	r, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	return resp, func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestTeamEventsHandler(t *testing.T) {
+	c := newTestContext("")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, body, err := TeamEventsHandler(c, w, r)
+		server.Respond(w, r, code, body, err)
+	}))
+	defer ts.Close()
+
+	resp, next := streamEvents(t, ts.URL, "")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if e := next(); e != "retry: 5000\n" {
+		t.Errorf("expected the reconnection delay, got %q", e)
+	}
+	c.teamEvents.Publish(eventPresence, presenceData{"alice", "active"})
+	e := next()
+	if !strings.Contains(e, "event: presence\ndata: {\"name\":\"alice\",\"presence\":\"active\"}\n") {
+		t.Fatalf("unexpected event %q", e)
+	}
+	id := strings.TrimPrefix(strings.SplitN(e, "\n", 2)[0], "id: ")
+
+	// Reconnecting clients catch up
+	c.teamEvents.Publish(eventLeave, leaveData{"bob"})
+	resp2, next2 := streamEvents(t, ts.URL, id)
+	defer resp2.Body.Close()
+	next2()
+	if e := next2(); !strings.Contains(e, "event: leave\n") {
+		t.Errorf("expected the missed event, got %q", e)
+	}
+
+	// Or start over when they missed too much
+	resp3, next3 := streamEvents(t, ts.URL, "1")
+	defer resp3.Body.Close()
+	next3()
+	if e := next3(); e != "event: reset\ndata: {}\n" {
+		t.Errorf("expected a reset, got %q", e)
+	}
+}
+
+func TestTeamEventsHandlerLimit(t *testing.T) {
+	c := newTestContext("")
+	c.teamEvents.SetMaxSubscribers(1)
+	subscription, _ := c.teamEvents.Subscribe("")
+	defer subscription.Close()
+
+	w := serve(c, TeamEventsHandler, httptest.NewRequest("GET", "/api/team/events", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected status 503 with Retry-After, got %d", w.Code)
+	}
+}
+
+func TestWatchTeam(t *testing.T) {
+	slack := &fakeSlack{body: testUsersList}
+	ts := httptest.NewServer(slack)
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+	c.settings.Team.PollInterval.Duration = 5 * time.Millisecond
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go WatchTeam(c, stop)
+
+	subscription, _ := c.teamEvents.Subscribe("")
+	defer subscription.Close()
+	time.Sleep(20 * time.Millisecond)
+	slack.mu.Lock()
+	slack.body = strings.Replace(testUsersList, `"presence": "away"`, `"presence": "active"`, 1)
+	slack.mu.Unlock()
+
+	select {
+	case e := <-subscription.Events():
+		if e.Type != eventPresence || string(e.Data) != `{"name":"bob","presence":"active"}` {
+			t.Errorf("unexpected event %s %s", e.Type, e.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a presence event")
+	}
+}
@@ -0,0 +1,286 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	avatars "github.com/lirios/website/avatars"
+)
+
+// matrixMember is a member of a Matrix room, as returned by joined_members.
+type matrixMember struct {
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// matrixJoinedMembers is the content of the joined_members response.
+type matrixJoinedMembers struct {
+	Joined map[string]matrixMember `json:"joined"`
+}
+
+// matrixPresence is the content of the presence status response.
+type matrixPresence struct {
+	Presence string `json:"presence"`
+}
+
+// matrixPowerLevels is the content of the m.room.power_levels state event.
+type matrixPowerLevels struct {
+	Users map[string]int `json:"users"`
+}
+
+// matrixAdminLevel is the power level from which a member is an administrator.
+const matrixAdminLevel = 100
+
+// matrixAvatarSizes are the sizes of the avatar thumbnails requested to the
+// homeserver, the largest one being the image published without the proxy.
+var matrixAvatarSizes = []int{96, 512}
+
+// matrixPresenceRequests is how many presence requests are sent at once.
+const matrixPresenceRequests = 8
+
+// matrixProvider retrieves the team from the members of a Matrix room or space.
+type matrixProvider struct{}
+
+// matrixClient performs requests to the client-server API of a homeserver.
+type matrixClient struct {
+	homeserver string
+	token      string
+}
+
+// get decodes the JSON response of a client-server API endpoint into v.
+func (m matrixClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", m.homeserver+"/_matrix/client/r0"+path, nil)
+	if err != nil {
+		return err
+	}
+	return m.do(req, v)
+}
+
+// matrixError is an error returned by the client-server API.
+type matrixError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"errcode"`
+	Message    string `json:"error"`
+}
+
+func (e *matrixError) Error() string {
+	return "matrix: " + e.Code + ": " + e.Message
+}
+
+// post sends v to a client-server API endpoint as JSON.
+func (m matrixClient) post(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", m.homeserver+"/_matrix/client/r0"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return m.do(req, nil)
+}
+
+// do performs an authenticated request, decoding the response into v
+// unless it is nil. Errors of the API are returned as a *matrixError.
+func (m matrixClient) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := &matrixError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Code == "" {
+			return fmt.Errorf("%s: unexpected response %q", req.URL.Host, resp.Status)
+		}
+		return e
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// thumbnailURL converts a mxc:// content URI to the HTTP URL of its thumbnail.
+func (m matrixClient) thumbnailURL(mxc string, size int) string {
+	u, err := url.Parse(mxc)
+	if err != nil || u.Scheme != "mxc" || u.Host == "" || len(u.Path) < 2 {
+		return ""
+	}
+	s := strconv.Itoa(size)
+	return m.homeserver + "/_matrix/media/r0/thumbnail/" + u.Host + u.Path +
+		"?width=" + s + "&height=" + s + "&method=scale"
+}
+
+// Members returns the members that joined the configured room.
+func (matrixProvider) Members(c Context) (filteredMembers, error) {
+	settings := c.Settings().Matrix
+	if settings.Homeserver == "" || settings.Room == "" {
+		return nil, errors.New("matrix: homeserver and room must be configured")
+	}
+	client := matrixClient{strings.TrimSuffix(settings.Homeserver, "/"), settings.Token}
+	room := url.PathEscape(settings.Room)
+
+	data := matrixJoinedMembers{}
+	if err := client.get("/rooms/"+room+"/joined_members", &data); err != nil {
+		return nil, err
+	}
+
+	// Power levels are optional, everybody is a regular member without them,
+	// but failing to get them would demote the administrators
+	levels := matrixPowerLevels{}
+	err := client.get("/rooms/"+room+"/state/m.room.power_levels", &levels)
+	if e, ok := err.(*matrixError); ok && e.Code == "M_NOT_FOUND" {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Room members come in no particular order
+	ids := make([]string, 0, len(data.Joined))
+	for id := range data.Joined {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	presences := client.presences(ids)
+
+	result := filteredMembers{}
+	for _, id := range ids {
+		v := data.Joined[id]
+		member := filteredMember{}
+		member.ID = id
+		member.Name = matrixLocalpart(id)
+		member.RealName = v.DisplayName
+		if member.RealName == "" {
+			member.RealName = member.Name
+		}
+		for _, size := range matrixAvatarSizes {
+			if image := client.thumbnailURL(v.AvatarURL, size); image != "" {
+				member.Image = image
+				member.Avatars = append(member.Avatars, avatars.Source{Size: size, URL: image})
+			}
+		}
+		if levels.Users[id] >= matrixAdminLevel {
+			member.setRole(roleAdmin)
+		} else {
+			member.setRole(roleMember)
+		}
+		member.Presence = presences[id]
+
+		result = append(result, member)
+	}
+
+	return result, nil
+}
+
+// presences returns the presence of the users, fetching up to
+// matrixPresenceRequests of them at a time. Users whose presence
+// can't be fetched, as when it is disabled on the homeserver,
+// are left out.
+func (m matrixClient) presences(ids []string) map[string]string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]string, len(ids))
+	slots := make(chan struct{}, matrixPresenceRequests)
+	for _, id := range ids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(id string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			presence := matrixPresence{}
+			if err := m.get("/presence/"+url.PathEscape(id)+"/status", &presence); err != nil {
+				return
+			}
+			mu.Lock()
+			result[id] = matrixPresenceName(presence.Presence)
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return result
+}
+
+// Invite invites someone to the room by email, through the identity
+// server, which sends the invitation.
+func (matrixProvider) Invite(c Context, email string) (string, error) {
+	settings := c.Settings().Matrix
+	if settings.IdentityServer == "" {
+		return "", errInvitesUnsupported
+	}
+	identityServer, err := url.Parse(settings.IdentityServer)
+	if err != nil {
+		return "", err
+	}
+	client := matrixClient{strings.TrimSuffix(settings.Homeserver, "/"), settings.Token}
+	err = client.post("/rooms/"+url.PathEscape(settings.Room)+"/invite", map[string]string{
+		"id_server":       identityServer.Host,
+		"id_access_token": settings.IdentityToken,
+		"medium":          "email",
+		"address":         email,
+	})
+	// A 403 is also what a wrong token or missing power gets, only
+	// the identity server refusing the address is the visitor's fault
+	if e, ok := err.(*matrixError); ok && e.Code == "M_THREEPID_DENIED" {
+		return inviteRejected, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return inviteInvited, nil
+}
+
+// matrixLocalpart returns the local part of a Matrix user ID
+// such as "alice" for "@alice:example.org".
+func matrixLocalpart(id string) string {
+	id = strings.TrimPrefix(id, "@")
+	if i := strings.Index(id, ":"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// matrixPresenceName maps Matrix presence states to the ones used by Slack,
+// which is what the site expects.
+func matrixPresenceName(presence string) string {
+	if presence == "online" {
+		return "active"
+	}
+	return "away"
+}
@@ -0,0 +1,171 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeHomeserver is a stand-in for the client-server API of a Matrix homeserver.
+func fakeHomeserver(t *testing.T) *httptest.Server {
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/r0/rooms/!liri:example.org/joined_members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer syt-test" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		reply(w, map[string]interface{}{
+			"joined": map[string]interface{}{
+				"@bob:example.org":   map[string]string{"display_name": "Bob"},
+				"@alice:example.org": map[string]string{"display_name": "Alice", "avatar_url": "mxc://example.org/abc"},
+			},
+		})
+	})
+	mux.HandleFunc("/_matrix/client/r0/rooms/!liri:example.org/state/m.room.power_levels", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"users": map[string]int{"@bob:example.org": 100}})
+	})
+	mux.HandleFunc("/_matrix/client/r0/presence/@alice:example.org/status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]string{"presence": "online"})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestMatrixProvider(t *testing.T) {
+	ts := fakeHomeserver(t)
+	defer ts.Close()
+
+	c := newTestContext("")
+	c.settings.Team.Provider = "matrix"
+	c.settings.Matrix.Homeserver = ts.URL + "/"
+	c.settings.Matrix.Token = "syt-test"
+	c.settings.Matrix.Room = "!liri:example.org"
+
+	code, data := getTeam(t, c)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(data.Members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(data.Members))
+	}
+	bob, alice := data.Members[0], data.Members[1]
+	if bob.Name != "bob" || bob.RealName != "Bob" || bob.Presence != "" {
+		t.Errorf("unexpected administrator %+v", bob)
+	}
+	if alice.Name != "alice" || alice.Presence != "active" {
+		t.Errorf("unexpected member %+v", alice)
+	}
+	if !strings.HasPrefix(alice.Image, "/avatars/@alice:example.org?v=") {
+		t.Errorf("expected a proxied image, got %q", alice.Image)
+	}
+
+	// The proxy resizes the homeserver thumbnails
+	cached, _ := c.TeamCache().Get()
+	thumbnail := ts.URL + "/_matrix/media/r0/thumbnail/example.org/abc?width=512&height=512&method=scale"
+	source, _ := cached.(filteredMembers)[0].Avatars.Closest(256)
+	if source.URL != thumbnail {
+		t.Errorf("expected thumbnail %q, got %q", thumbnail, source.URL)
+	}
+}
+
+func TestMatrixProviderPowerLevels(t *testing.T) {
+	status, body := http.StatusNotFound, `{"errcode": "M_NOT_FOUND", "error": "Event not found"}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/r0/rooms/!liri:example.org/joined_members", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"joined": {"@bob:example.org": {"display_name": "Bob"}}}`))
+	})
+	mux.HandleFunc("/_matrix/client/r0/rooms/!liri:example.org/state/m.room.power_levels", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := newTestContext("")
+	c.settings.Team.Provider = "matrix"
+	c.settings.Matrix.Homeserver = ts.URL
+	c.settings.Matrix.Token = "syt-test"
+	c.settings.Matrix.Room = "!liri:example.org"
+
+	// Rooms without power levels have no administrators
+	members, err := (matrixProvider{}).Members(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].IsAdmin {
+		t.Errorf("expected a regular member, got %+v", members)
+	}
+
+	// Other failures are not mistaken for the lack of power levels
+	status, body = http.StatusForbidden, `{"errcode": "M_FORBIDDEN", "error": "Not in the room"}`
+	if _, err := (matrixProvider{}).Members(c); err == nil {
+		t.Error("expected the power levels error to be returned")
+	}
+}
+
+func TestTeamHandlerMatrixError(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		code   int
+		id     string
+	}{
+		{http.StatusUnauthorized, `{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid token"}`, http.StatusBadGateway, "upstream_auth"},
+		{http.StatusForbidden, `{"errcode": "M_FORBIDDEN", "error": "Not in the room"}`, http.StatusBadGateway, "upstream_error"},
+		{http.StatusBadGateway, `{"errcode": "M_UNKNOWN", "error": "Try again"}`, http.StatusServiceUnavailable, "upstream_unavailable"},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		c := newTestContext("")
+		c.settings.Team.Provider = "matrix"
+		c.settings.Matrix.Homeserver = ts.URL
+		c.settings.Matrix.Token = "syt-test"
+		c.settings.Matrix.Room = "!liri:example.org"
+		w := serve(c, TeamHandler, httptest.NewRequest("GET", "/api/team", nil))
+		ts.Close()
+
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.id, test.code, w.Code)
+		}
+		var data struct {
+			Error   string            `json:"error"`
+			Details map[string]string `json:"details"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Error != test.id || data.Details["matrix_error"] == "" {
+			t.Errorf("unexpected error %+v, expected %s", data, test.id)
+		}
+	}
+}
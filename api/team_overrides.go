@@ -0,0 +1,126 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	avatars "github.com/lirios/website/avatars"
+	server "github.com/lirios/website/server"
+)
+
+// curated returns a copy of the members merged with the curated
+// information of the settings: hidden members are removed, what the
+// chat service says about the others is replaced, and members missing
+// from the chat service are added.
+func curated(members filteredMembers, settings *server.Settings) filteredMembers {
+	result := filteredMembers{}
+	for _, m := range members {
+		if o, ok := settings.Member[m.ID]; ok {
+			if o.Hide {
+				continue
+			}
+			m = override(m, o)
+		}
+		result = append(result, m)
+	}
+
+	ids := make([]string, 0, len(settings.Extra))
+	for id := range settings.Extra {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		o := settings.Extra[id]
+		if o.Hide {
+			continue
+		}
+		m := filteredMember{ID: id}
+		m.setRole(roleMember)
+		result = append(result, override(m, o))
+	}
+	return result
+}
+
+// override replaces the information about a member with the curated one.
+func override(m filteredMember, o *server.MemberSettings) filteredMember {
+	if o.Name != "" {
+		m.Name = o.Name
+	}
+	if o.RealName != "" {
+		m.RealName = o.RealName
+	}
+	if o.Image != "" {
+		// The size of curated images isn't known
+		m.Image = o.Image
+		m.Avatars = avatars.Sources{{URL: o.Image}}
+	}
+	if o.Title != "" {
+		m.Title = o.Title
+	}
+	if o.Role != "" {
+		m.setRole(o.Role)
+	}
+	if !o.Joined.IsZero() {
+		m.Joined = o.Joined.Time
+	}
+	if o.GitHub != "" {
+		m.GitHub = gitHubProfileURL(o.GitHub)
+	}
+	if o.Mastodon != "" {
+		m.Mastodon = mastodonProfileURL(o.Mastodon)
+	}
+	if o.Website != "" {
+		m.Website = o.Website
+	}
+	return m
+}
+
+// isURL returns whether s is an absolute http URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// gitHubProfileURL returns the profile URL of a GitHub login.
+func gitHubProfileURL(login string) string {
+	if isURL(login) {
+		return login
+	}
+	return "https://github.com/" + url.PathEscape(strings.TrimPrefix(login, "@"))
+}
+
+// mastodonProfileURL returns the profile URL of a Mastodon account
+// such as @alice@mastodon.social.
+func mastodonProfileURL(account string) string {
+	if isURL(account) {
+		return account
+	}
+	parts := strings.Split(strings.TrimPrefix(account, "@"), "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return account
+	}
+	return "https://" + parts[1] + "/@" + url.PathEscape(parts[0])
+}
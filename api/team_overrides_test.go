@@ -0,0 +1,119 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	server "github.com/lirios/website/server"
+)
+
+func TestCurated(t *testing.T) {
+	members := filteredMembers{
+		{ID: "U1", Name: "alice", RealName: "Alice", Image: "https://example.com/alice.png", Presence: "active", Role: "member"},
+		{ID: "U2", Name: "bob", RealName: "Bob", Role: "admin", IsAdmin: true},
+		{ID: "U3", Name: "carol", RealName: "Carol", Role: "member"},
+	}
+	upstream := make(filteredMembers, len(members))
+	copy(upstream, members)
+
+	settings := &server.Settings{}
+	settings.Member = map[string]*server.MemberSettings{
+		"U1": {
+			RealName: "Alice Liddell",
+			Image:    "https://example.org/alice.jpg",
+			Title:    "Design",
+			GitHub:   "alice",
+			Mastodon: "@alice@mastodon.social",
+			Website:  "https://alice.example.org",
+			Joined:   server.Date{Time: time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		"U2": {Hide: true},
+		"U9": {Title: "Nobody"},
+	}
+	settings.Extra = map[string]*server.MemberSettings{
+		"zed":  {Name: "zed", RealName: "Zed", Role: "owner", GitHub: "https://github.com/zed"},
+		"dan":  {Name: "dan"},
+		"gone": {Name: "gone", Hide: true},
+	}
+
+	result := curated(members, settings)
+	if !reflect.DeepEqual(members, upstream) {
+		t.Error("the upstream members must not be modified")
+	}
+
+	var names []string
+	for _, m := range result {
+		names = append(names, m.Name)
+	}
+	expected := []string{"alice", "carol", "dan", "zed"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+
+	alice := result[0]
+	if alice.RealName != "Alice Liddell" || alice.Image != "https://example.org/alice.jpg" || alice.Title != "Design" {
+		t.Errorf("overrides not applied: %+v", alice)
+	}
+	if alice.Presence != "active" || alice.Role != "member" {
+		t.Errorf("upstream information lost: %+v", alice)
+	}
+	if alice.GitHub != "https://github.com/alice" || alice.Mastodon != "https://mastodon.social/@alice" || alice.Website != "https://alice.example.org" {
+		t.Errorf("unexpected links: %+v", alice)
+	}
+	if alice.Joined.Year() != 2016 {
+		t.Errorf("unexpected join date %v", alice.Joined)
+	}
+	if carol := result[1]; !reflect.DeepEqual(carol, members[2]) {
+		t.Errorf("expected carol unchanged, got %+v", carol)
+	}
+
+	dan, zed := result[2], result[3]
+	if dan.ID != "dan" || dan.Role != "member" || dan.IsAdmin {
+		t.Errorf("unexpected extra member %+v", dan)
+	}
+	if zed.RealName != "Zed" || zed.Role != "owner" || !zed.IsOwner || !zed.IsAdmin || zed.GitHub != "https://github.com/zed" {
+		t.Errorf("unexpected extra member %+v", zed)
+	}
+}
+
+func TestProfileURLs(t *testing.T) {
+	tests := []struct {
+		f        func(string) string
+		in, want string
+	}{
+		{gitHubProfileURL, "@alice", "https://github.com/alice"},
+		{gitHubProfileURL, "https://github.com/alice", "https://github.com/alice"},
+		{mastodonProfileURL, "alice@fosstodon.org", "https://fosstodon.org/@alice"},
+		{mastodonProfileURL, "https://fosstodon.org/@alice", "https://fosstodon.org/@alice"},
+		{mastodonProfileURL, "alice", "alice"},
+	}
+	for _, test := range tests {
+		if got := test.f(test.in); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.in, test.want, got)
+		}
+	}
+}
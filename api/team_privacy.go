@@ -0,0 +1,134 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"fmt"
+
+	server "github.com/lirios/website/server"
+)
+
+// Reasons for not publishing a member.
+const (
+	reasonHidden     = "hidden by the overrides"
+	reasonOptedOut   = "opted out"
+	reasonNotOptedIn = "not opted in"
+)
+
+// privacyPolicy decides what is published about the members.
+type privacyPolicy struct {
+	settings *server.Settings
+}
+
+// published returns whether a member can be published and, if not, why.
+// Members added by the overrides were curated by the maintainers and
+// are published.
+func (p privacyPolicy) published(m filteredMember) (bool, string) {
+	privacy := p.settings.Privacy
+	if contains(privacy.OptOut, m.ID) {
+		return false, reasonOptedOut
+	}
+	if privacy.Policy == server.PrivacyOptIn && !contains(privacy.OptIn, m.ID) && p.settings.Extra[m.ID] == nil {
+		return false, reasonNotOptedIn
+	}
+	return true, ""
+}
+
+// minimize removes the information about a member that must not be published.
+func (p privacyPolicy) minimize(m filteredMember) filteredMember {
+	privacy := p.settings.Privacy
+	if privacy.RealName == server.Hide {
+		m.RealName = ""
+	}
+	switch privacy.Tz {
+	case server.PublishOffset:
+		if m.Tz != "" {
+			m.Tz = utcOffset(m.TzOffset)
+		}
+	case server.Hide:
+		m.Tz, m.TzOffset = "", 0
+	}
+	if privacy.Presence == server.Hide {
+		m.Presence = ""
+	}
+	if privacy.Image == server.Hide {
+		m.Image, m.Avatars = "", nil
+	}
+	return m
+}
+
+// apply returns the members that can be published, with only
+// the information that can be published.
+func (p privacyPolicy) apply(members filteredMembers) filteredMembers {
+	result := filteredMembers{}
+	for _, m := range members {
+		if ok, _ := p.published(m); ok {
+			result = append(result, p.minimize(m))
+		}
+	}
+	return result
+}
+
+// publishedFields returns the names of the fields published about a member.
+func publishedFields(m filteredMember) []string {
+	fields := []string{"name", "role"}
+	optional := []struct {
+		name  string
+		value string
+	}{
+		{"real_name", m.RealName},
+		{"tz", m.Tz},
+		{"image", m.Image},
+		{"presence", m.Presence},
+		{"title", m.Title},
+		{"github", m.GitHub},
+		{"mastodon", m.Mastodon},
+		{"website", m.Website},
+	}
+	for _, field := range optional {
+		if field.value != "" {
+			fields = append(fields, field.name)
+		}
+	}
+	return fields
+}
+
+// utcOffset formats a timezone offset in seconds such as UTC+02:00.
+func utcOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("UTC%c%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+// contains returns whether s is in list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
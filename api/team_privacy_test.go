@@ -0,0 +1,138 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	server "github.com/lirios/website/server"
+)
+
+func TestPrivacyPolicy(t *testing.T) {
+	settings := server.Defaults()
+	settings.Privacy.OptOut = []string{"U2"}
+	settings.Privacy.Tz = server.PublishOffset
+	settings.Privacy.Presence = server.Hide
+	policy := privacyPolicy{settings}
+
+	members := filteredMembers{
+		{ID: "U1", Name: "alice", RealName: "Alice", Tz: "Asia/Kolkata", TzOffset: 19800, Presence: "active"},
+		{ID: "U2", Name: "bob"},
+		{ID: "U3", Name: "carol", Tz: "America/Los_Angeles", TzOffset: -28800},
+	}
+	result := policy.apply(members)
+	if len(result) != 2 || result[0].Name != "alice" || result[1].Name != "carol" {
+		t.Fatalf("expected bob to be left out, got %+v", result)
+	}
+	if result[0].Tz != "UTC+05:30" || result[1].Tz != "UTC-08:00" {
+		t.Errorf("expected timezones as offsets, got %q and %q", result[0].Tz, result[1].Tz)
+	}
+	if result[0].Presence != "" {
+		t.Errorf("expected presence to be hidden, got %q", result[0].Presence)
+	}
+	if members[0].Tz != "Asia/Kolkata" {
+		t.Error("the members must not be modified")
+	}
+
+	settings.Privacy.Policy = server.PrivacyOptIn
+	settings.Privacy.OptIn = []string{"U3"}
+	settings.Extra = map[string]*server.MemberSettings{"U4": {Name: "dan"}}
+	members = append(members, filteredMember{ID: "U4", Name: "dan"})
+	var names []string
+	for _, m := range policy.apply(members) {
+		names = append(names, m.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"carol", "dan"}) {
+		t.Errorf("expected only the members opted in and the extra ones, got %v", names)
+	}
+}
+
+func TestTeamHandlerPrivacy(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: testUsersList})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+	c.settings.Privacy.Presence = server.Hide
+
+	// Hidden fields can't be inferred with the filters
+	w := serve(c, TeamHandler, httptest.NewRequest("GET", "/api/team?presence=active", nil))
+	var data filteredUserListData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Total != 0 {
+		t.Errorf("expected no member to match a hidden field, got %d", data.Total)
+	}
+}
+
+func TestTeamAuditHandler(t *testing.T) {
+	ts := httptest.NewServer(&fakeSlack{body: testUsersList})
+	defer ts.Close()
+	c := newTestContext(ts.URL)
+	c.settings.Privacy.RealName = server.Hide
+	c.settings.Privacy.OptOut = []string{"U2"}
+
+	audit := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/team/audit", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return serve(c, TeamAuditHandler, r)
+	}
+
+	if w := audit("anything"); w.Code != http.StatusNotFound {
+		t.Errorf("expected the audit to be disabled without a token, got %d", w.Code)
+	}
+	c.settings.Privacy.AuditToken = "audit-secret"
+	if w := audit("wrong"); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected status 401 for a wrong token, got %d", w.Code)
+	}
+
+	w := audit("audit-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var data auditData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	expected := []auditEntry{
+		{ID: "U1", Name: "alice", Published: true, Fields: []string{"name", "role", "tz", "image", "presence"}},
+		{ID: "U2", Name: "bob", Reason: reasonOptedOut, Fields: []string{}},
+	}
+	if !reflect.DeepEqual(data.Members, expected) {
+		t.Errorf("expected %+v, got %+v", expected, data.Members)
+	}
+}
+
+func TestUTCOffset(t *testing.T) {
+	for offset, expected := range map[int]string{0: "UTC+00:00", 3600: "UTC+01:00", -12600: "UTC-03:30"} {
+		if s := utcOffset(offset); s != expected {
+			t.Errorf("%d: expected %q, got %q", offset, expected, s)
+		}
+	}
+}
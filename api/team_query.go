@@ -0,0 +1,139 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	server "github.com/lirios/website/server"
+)
+
+// teamQuery selects the members returned by the team API.
+type teamQuery struct {
+	limit    int
+	offset   int
+	search   string
+	presence string
+	tz       string
+	roles    []string
+	order    memberOrder
+}
+
+// parseTeamQuery reads the paging, filters and order from the query string,
+// sorting by defaultOrder unless asked otherwise.
+func parseTeamQuery(values url.Values, defaultOrder string) (teamQuery, error) {
+	q := teamQuery{
+		search:   strings.ToLower(strings.TrimSpace(values.Get("q"))),
+		presence: values.Get("presence"),
+		tz:       values.Get("tz"),
+	}
+	var err error
+	if q.limit, err = parseCount(values, "limit"); err != nil {
+		return q, err
+	}
+	if q.offset, err = parseCount(values, "offset"); err != nil {
+		return q, err
+	}
+	order := values.Get("sort")
+	if order == "" {
+		order = defaultOrder
+	}
+	if order == "" {
+		order = server.DefaultTeamSort
+	}
+	if q.order, err = parseMemberOrder(order); err != nil {
+		return q, err
+	}
+	if roles := values.Get("role"); roles != "" {
+		for _, role := range strings.Split(roles, ",") {
+			if roleRank(role) == len(memberRoles) {
+				return q, server.BadRequest("unknown role " + strconv.Quote(role))
+			}
+			q.roles = append(q.roles, role)
+		}
+	}
+	return q, nil
+}
+
+// parseCount reads a non-negative number from the query string,
+// returning 0 when it's missing.
+func parseCount(values url.Values, name string) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, server.BadRequest(name + " must be a non-negative number")
+	}
+	return n, nil
+}
+
+// matches returns whether a member passes the filters.
+func (q teamQuery) matches(m filteredMember) bool {
+	if q.search != "" &&
+		!strings.Contains(strings.ToLower(m.Name), q.search) &&
+		!strings.Contains(strings.ToLower(m.RealName), q.search) {
+		return false
+	}
+	if q.presence != "" && m.Presence != q.presence {
+		return false
+	}
+	if q.tz != "" && !strings.EqualFold(m.Tz, q.tz) {
+		return false
+	}
+	if len(q.roles) == 0 {
+		return true
+	}
+	for _, role := range q.roles {
+		if m.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the page of sorted members passing the filters, along with
+// how many passed them. The members are not modified, so that they
+// can come from the cache.
+func (q teamQuery) apply(members filteredMembers) (filteredMembers, int) {
+	result := filteredMembers{}
+	for _, m := range members {
+		if q.matches(m) {
+			result = append(result, m)
+		}
+	}
+	q.order.sort(result)
+	total := len(result)
+	if q.offset >= total {
+		return filteredMembers{}, total
+	}
+	result = result[q.offset:]
+	if q.limit > 0 && q.limit < len(result) {
+		result = result[:q.limit]
+	}
+	return result, total
+}
@@ -0,0 +1,140 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ * Copyright (C) 2016 Ziga Patacko Koderman <ziga.patacko@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"net/url"
+
+	avatars "github.com/lirios/website/avatars"
+	server "github.com/lirios/website/server"
+	slack "github.com/lirios/website/slack"
+)
+
+// slackProvider retrieves the team from a Slack workspace.
+type slackProvider struct{}
+
+// slackClient returns a client for the configured workspace.
+func slackClient(c Context) *slack.Client {
+	baseURL := c.Settings().Slack.URL
+	if baseURL == "" {
+		baseURL = server.DefaultSlackURL
+	}
+	client := slack.NewClient(baseURL, c.Settings().Slack.Token)
+	if pageSize := c.Settings().Slack.PageSize; pageSize > 0 {
+		client.PageSize = pageSize
+	}
+	return client
+}
+
+// Members returns the Slack workspace members.
+func (slackProvider) Members(c Context) (filteredMembers, error) {
+	users, err := slackClient(c).UsersList()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude deleted members, bots and filter out some information
+	result := filteredMembers{}
+	for _, v := range users {
+		if isSlackMember(v) {
+			result = append(result, filteredSlackUser(v))
+		}
+	}
+	return result, nil
+}
+
+// Invite invites someone to the Slack workspace. Asking again for
+// someone already invited counts as being invited.
+func (slackProvider) Invite(c Context, email string) (string, error) {
+	err := slackClient(c).InviteUser(email)
+	if e, ok := err.(*slack.Error); ok {
+		switch e.Code {
+		case slack.AlreadyInTeam, slack.AlreadyInTeamInvited:
+			return inviteAlreadyMember, nil
+		case slack.AlreadyInvited, slack.SentRecently:
+			return inviteInvited, nil
+		case slack.InvalidEmail:
+			return inviteRejected, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return inviteInvited, nil
+}
+
+// isSlackMember returns whether a Slack user is a member of the team.
+func isSlackMember(u slack.User) bool {
+	return u.ID != "USLACKBOT" && !u.IsBot && !u.Deleted
+}
+
+// filteredSlackUser returns the information about a Slack member we publish.
+func filteredSlackUser(u slack.User) filteredMember {
+	member := filteredMember{}
+	member.ID = u.ID
+	member.Name = u.Name
+	member.RealName = u.RealName
+	member.Tz = u.Tz
+	member.TzOffset = u.TzOffset
+	member.Image = slackImageURL(u.Profile.Image512)
+	images := []struct {
+		size int
+		url  string
+	}{
+		{24, u.Profile.Image24},
+		{32, u.Profile.Image32},
+		{48, u.Profile.Image48},
+		{72, u.Profile.Image72},
+		{192, u.Profile.Image192},
+		{512, u.Profile.Image512},
+		{1024, u.Profile.Image1024},
+	}
+	for _, image := range images {
+		if image.url != "" {
+			member.Avatars = append(member.Avatars, avatars.Source{Size: image.size, URL: slackImageURL(image.url)})
+		}
+	}
+	member.Presence = u.Presence
+	switch {
+	case u.IsOwner || u.IsPrimaryOwner:
+		member.setRole(roleOwner)
+	case u.IsAdmin:
+		member.setRole(roleAdmin)
+	case u.IsRestricted || u.IsUltraRestricted:
+		member.setRole(roleGuest)
+	default:
+		member.setRole(roleMember)
+	}
+	return member
+}
+
+// slackImageURL returns the URL of a profile image, which
+// Slack might have escaped.
+func slackImageURL(image string) string {
+	if u, err := url.QueryUnescape(image); err == nil {
+		return u
+	}
+	return image
+}
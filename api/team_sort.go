@@ -0,0 +1,151 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	server "github.com/lirios/website/server"
+)
+
+// memberComparers compare two members by a sort key,
+// returning a negative number when a goes before b.
+var memberComparers = map[string]func(a, b *filteredMember) int{
+	// Most privileged first
+	"role": func(a, b *filteredMember) int {
+		return roleRank(a.Role) - roleRank(b.Role)
+	},
+	"name": func(a, b *filteredMember) int {
+		return strings.Compare(strings.ToLower(a.displayName()), strings.ToLower(b.displayName()))
+	},
+	// Neither Slack nor Matrix tell when people joined, so the date
+	// only comes from the joined override and members without one
+	// count as the latest to join
+	"joined": func(a, b *filteredMember) int {
+		switch {
+		case a.Joined.IsZero() || b.Joined.IsZero():
+			return compareBool(!a.Joined.IsZero(), !b.Joined.IsZero())
+		case a.Joined.Before(b.Joined):
+			return -1
+		case b.Joined.Before(a.Joined):
+			return 1
+		}
+		return 0
+	},
+	// Online members first
+	"presence": func(a, b *filteredMember) int {
+		return compareBool(a.Presence == "active", b.Presence == "active")
+	},
+	// Westmost first
+	"tz": func(a, b *filteredMember) int {
+		return a.TzOffset - b.TzOffset
+	},
+}
+
+// compareBool puts true before false.
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case b && !a:
+		return 1
+	}
+	return 0
+}
+
+// memberSortKey is a key members are sorted by.
+type memberSortKey struct {
+	compare    func(a, b *filteredMember) int
+	descending bool
+}
+
+// memberOrder is the list of keys members are sorted by.
+type memberOrder []memberSortKey
+
+// parseMemberOrder parses a comma separated list of sort keys such as
+// "role,-joined", where a minus sign reverses the order of a key.
+func parseMemberOrder(s string) (memberOrder, error) {
+	var order memberOrder
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		key := memberSortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.descending = true
+			name = name[1:]
+		}
+		compare, ok := memberComparers[name]
+		if !ok {
+			return nil, server.BadRequest("unknown sort key " + strconv.Quote(name))
+		}
+		key.compare = compare
+		order = append(order, key)
+	}
+	return order, nil
+}
+
+// sort sorts the members. Ties are broken by user name, then by ID
+// as names are not unique, so that members keep the same order
+// across requests.
+func (order memberOrder) sort(members filteredMembers) {
+	sort.Sort(memberSorter{members, order})
+}
+
+// memberSorter sorts members by a list of keys.
+type memberSorter struct {
+	members filteredMembers
+	order   memberOrder
+}
+
+// Len returns the length of the slice.
+func (s memberSorter) Len() int {
+	return len(s.members)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (s memberSorter) Less(i, j int) bool {
+	a, b := &s.members[i], &s.members[j]
+	for _, key := range s.order {
+		c := key.compare(a, b)
+		if key.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.ID < b.ID
+}
+
+// Swap swaps two slice items.
+func (s memberSorter) Swap(i, j int) {
+	s.members[i], s.members[j] = s.members[j], s.members[i]
+}
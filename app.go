@@ -0,0 +1,315 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	api "github.com/lirios/website/api"
+	avatars "github.com/lirios/website/avatars"
+	events "github.com/lirios/website/events"
+	geoip "github.com/lirios/website/geoip"
+	invite "github.com/lirios/website/invite"
+	mirrors "github.com/lirios/website/mirrors"
+	server "github.com/lirios/website/server"
+)
+
+// settingsPollInterval is how often the settings file is checked for changes.
+const settingsPollInterval = 5 * time.Second
+
+// Context of the application.
+type ctx struct {
+	settings          atomic.Value
+	teamCache         *server.Cache
+	contributorsCache *server.Cache
+	releasesCache     *server.Cache
+	mirrors           *mirrors.Pool
+	teamEvents        *events.Broker
+	invites           *invite.Guard
+
+	mu      sync.RWMutex
+	geoIP   *geoip.Database
+	avatars *avatars.Cache
+}
+
+// Settings returns a snapshot of the settings, which must not be modified.
+func (c *ctx) Settings() *server.Settings {
+	return c.settings.Load().(*server.Settings)
+}
+
+func (c *ctx) TeamCache() *server.Cache {
+	return c.teamCache
+}
+
+func (c *ctx) ContributorsCache() *server.Cache {
+	return c.contributorsCache
+}
+
+func (c *ctx) ReleasesCache() *server.Cache {
+	return c.releasesCache
+}
+
+func (c *ctx) Mirrors() *mirrors.Pool {
+	return c.mirrors
+}
+
+func (c *ctx) TeamEvents() *events.Broker {
+	return c.teamEvents
+}
+
+func (c *ctx) Invites() *invite.Guard {
+	return c.invites
+}
+
+func (c *ctx) GeoIP() *geoip.Database {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.geoIP
+}
+
+func (c *ctx) Avatars() *avatars.Cache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.avatars
+}
+
+// newContext creates the application context.
+func newContext(settings *server.Settings) (*ctx, error) {
+	c := &ctx{}
+	c.teamCache = server.NewCache(settings.Team.CacheTTL.Duration, func() (interface{}, error) {
+		return api.FetchTeam(c)
+	})
+	c.teamCache.OnUpdate(func(old, new interface{}) {
+		api.PublishTeamChanges(c, old, new)
+	})
+	c.contributorsCache = server.NewCache(settings.GitHub.CacheTTL.Duration, func() (interface{}, error) {
+		return api.FetchContributors(c)
+	})
+	c.releasesCache = server.NewCache(settings.Releases.CacheTTL.Duration, func() (interface{}, error) {
+		return api.FetchReleases(c)
+	})
+	c.mirrors = mirrors.NewPool(nil, mirrors.Options{})
+	c.teamEvents = events.NewBroker(settings.Team.MaxSubscribers, events.DefaultHistorySize)
+	c.invites = invite.NewGuard()
+	if err := c.apply(settings); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// apply makes the settings effective.
+// When an error is returned the current settings are kept.
+func (c *ctx) apply(settings *server.Settings) error {
+	old, _ := c.settings.Load().(*server.Settings)
+
+	// Download mirrors are located with the GeoIP database, if any
+	db := c.GeoIP()
+	if old == nil || old.Download.GeoIP != settings.Download.GeoIP {
+		db = nil
+		if settings.Download.GeoIP != "" {
+			var err error
+			db, err = geoip.Open(settings.Download.GeoIP)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cache := c.Avatars()
+	if cache == nil || cache.Dir() != settings.Avatars.Directory {
+		var err error
+		cache, err = avatars.NewCache(settings.Avatars.Directory)
+		if err != nil {
+			return err
+		}
+	}
+	maxSize := int64(avatars.DefaultMaxSize)
+	if settings.Avatars.MaxSize > 0 {
+		maxSize = int64(settings.Avatars.MaxSize) << 20
+	}
+	if err := cache.SetMaxSize(maxSize); err != nil {
+		return err
+	}
+
+	c.settings.Store(settings)
+	c.mu.Lock()
+	c.geoIP = db
+	c.avatars = cache
+	c.mu.Unlock()
+
+	// Cached data might come from a different source now
+	c.teamCache.SetTTL(settings.Team.CacheTTL.Duration)
+	c.contributorsCache.SetTTL(settings.GitHub.CacheTTL.Duration)
+	c.releasesCache.SetTTL(settings.Releases.CacheTTL.Duration)
+	if old != nil {
+		caches := []struct {
+			cache  *server.Cache
+			source func(s *server.Settings) interface{}
+		}{
+			{c.teamCache, teamSource},
+			{c.contributorsCache, contributorsSource},
+			{c.releasesCache, releasesSource},
+		}
+		for _, cache := range caches {
+			if !reflect.DeepEqual(cache.source(old), cache.source(settings)) {
+				cache.cache.Expire()
+			}
+		}
+	}
+
+	c.teamEvents.SetMaxSubscribers(settings.Team.MaxSubscribers)
+	c.mirrors.Update(mirrorList(settings), mirrors.Options{
+		Interval: settings.Download.CheckInterval.Duration,
+		Trace:    settings.Download.Trace,
+		MaxLag:   settings.Download.MaxLag.Duration,
+	})
+	return nil
+}
+
+// teamSource returns the settings the team is fetched with.
+func teamSource(s *server.Settings) interface{} {
+	return []interface{}{s.Team.Provider, s.Slack, s.Matrix}
+}
+
+// contributorsSource returns the settings the contributors are fetched with.
+func contributorsSource(s *server.Settings) interface{} {
+	source := s.GitHub
+	source.CacheTTL = server.Duration{}
+	return source
+}
+
+// releasesSource returns the settings the releases are loaded with.
+func releasesSource(s *server.Settings) interface{} {
+	source := s.Releases
+	source.CacheTTL = server.Duration{}
+	return source
+}
+
+// reload reads the settings again, keeping the current
+// settings if the new ones are not valid.
+func (c *ctx) reload(loader *settingsLoader) error {
+	settings, err := loader.load()
+	if err != nil {
+		return err
+	}
+	old := c.Settings()
+	if err := c.apply(settings); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(settings.Server, old.Server) {
+		log.Printf("Server settings changed, restart to apply them")
+	}
+	return nil
+}
+
+// watchSettings reloads the settings file when SIGHUP is received
+// or the file is modified, until stop is closed.
+func (c *ctx) watchSettings(loader *settingsLoader, stop <-chan struct{}) {
+	// Secrets and overrides read from files are watched as well
+	files := func() []string {
+		files := append([]string{loader.fileName}, c.Settings().SecretFiles()...)
+		if overrides := c.Settings().Team.Overrides; overrides != "" {
+			files = append(files, overrides)
+		}
+		return files
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(settingsPollInterval)
+	defer ticker.Stop()
+
+	modified := modTimes(files())
+	for {
+		select {
+		case <-signals:
+			log.Printf("Received SIGHUP, reloading settings")
+		case <-ticker.C:
+			if modTimes(files()) == modified {
+				continue
+			}
+			log.Printf("Settings files changed, reloading settings")
+		case <-stop:
+			return
+		}
+		if err := c.reload(loader); err != nil {
+			log.Printf("Failed to reload settings, keeping the current ones: %v", err)
+		} else {
+			log.Printf("Settings reloaded")
+		}
+		modified = modTimes(files())
+	}
+}
+
+// modTime returns the modification time of a file, or the zero time
+// if it doesn't exist.
+func modTime(fileName string) time.Time {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// modTimes summarizes the modification times of some files,
+// the result changes when any of them is modified.
+func modTimes(fileNames []string) string {
+	var b bytes.Buffer
+	for _, fileName := range fileNames {
+		fmt.Fprintf(&b, "%s %d\n", fileName, modTime(fileName).UnixNano())
+	}
+	return b.String()
+}
+
+// mirrorList returns the download mirrors from the settings.
+func mirrorList(settings *server.Settings) []*mirrors.Mirror {
+	var names []string
+	for name := range settings.Mirror {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []*mirrors.Mirror
+	for _, name := range names {
+		m := settings.Mirror[name]
+		list = append(list, &mirrors.Mirror{
+			Name:      name,
+			URL:       m.URL,
+			AltURLs:   m.AltURL,
+			Weight:    m.Weight,
+			Country:   m.Country,
+			Continent: m.Continent,
+		})
+	}
+	return list
+}
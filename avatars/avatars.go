@@ -0,0 +1,513 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package avatars serves resized copies of remote avatars from a disk cache.
+package avatars
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers the GIF format
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSize is the size of avatars when none is asked for.
+	DefaultSize = 512
+
+	// DefaultMaxSize is how many bytes the cache takes by default.
+	DefaultMaxSize = 256 << 20
+
+	// touchInterval is how often the modification time of the files
+	// in use is updated, the least recently used ones being evicted.
+	touchInterval = time.Hour
+
+	// evictRatio is how much of the maximum size is left after evicting.
+	evictRatio = 0.9
+
+	// fetchTimeout is how long to wait for the remote image.
+	fetchTimeout = 15 * time.Second
+
+	// maxSourceBytes and maxSourcePixels limit the remote images
+	// accepted, so that a huge one doesn't take the server down.
+	maxSourceBytes  = 8 << 20
+	maxSourcePixels = 4096 * 4096
+
+	jpegQuality = 90
+)
+
+// DefaultDirectory is where avatars are cached by default.
+var DefaultDirectory = filepath.Join(os.TempDir(), "liri-website-avatars")
+
+// Sizes avatars are resized to. Sizes in between are rounded up,
+// so that the cache doesn't grow with every size a client asks for.
+var Sizes = []int{24, 32, 48, 64, 72, 96, 128, 192, 256, 512, 1024}
+
+// RoundSize returns the smallest of Sizes that is at least size.
+func RoundSize(size int) int {
+	for _, s := range Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
+
+// Source is a remote copy of an avatar.
+type Source struct {
+	// Size of the image, 0 when not known.
+	Size int
+	URL  string
+}
+
+// Sources are the copies of an avatar, in different sizes.
+type Sources []Source
+
+// Closest returns the source to resize to the given size: the smallest
+// one that is large enough, then one of unknown size and finally the
+// largest one.
+func (s Sources) Closest(size int) (Source, bool) {
+	var best, unknown, largest *Source
+	for i := range s {
+		src := &s[i]
+		switch {
+		case src.Size == 0:
+			if unknown == nil {
+				unknown = src
+			}
+		case src.Size >= size:
+			if best == nil || src.Size < best.Size {
+				best = src
+			}
+		default:
+			if largest == nil || src.Size > largest.Size {
+				largest = src
+			}
+		}
+	}
+	for _, src := range []*Source{best, unknown, largest} {
+		if src != nil {
+			return *src, true
+		}
+	}
+	return Source{}, false
+}
+
+// Version returns a short hash of the sources, which changes
+// when the avatar does.
+func (s Sources) Version() string {
+	h := sha256.New()
+	for _, src := range s {
+		fmt.Fprintf(h, "%d %s\n", src.Size, src.URL)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// AcceptsWebP returns whether the Accept header lists WebP images.
+// Wildcards are ignored, as browsers that can show WebP say so.
+// AVIF is not offered, there being no encoder without cgo.
+func AcceptsWebP(accept string) bool {
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		if strings.ToLower(strings.TrimSpace(params[0])) != "image/webp" {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Image is an encoded avatar.
+type Image struct {
+	Data        []byte
+	ContentType string
+	// ETag identifies the image, quoted.
+	ETag string
+}
+
+// format is an encoding avatars are stored in.
+type format struct {
+	ext         string
+	contentType string
+	encode      func(io.Writer, image.Image) error
+	// suits returns whether the format can store the image.
+	suits func(image.Image) bool
+}
+
+var (
+	// JPEG is smaller for photos, PNG keeps transparency.
+	fallbackFormats = []format{
+		{".jpg", "image/jpeg", encodeJPEG, opaque},
+		{".png", "image/png", png.Encode, always},
+	}
+	webpFormats = []format{
+		{".webp", "image/webp", EncodeWebP, always},
+	}
+)
+
+func encodeJPEG(w io.Writer, m image.Image) error {
+	return jpeg.Encode(w, m, &jpeg.Options{Quality: jpegQuality})
+}
+
+func always(image.Image) bool {
+	return true
+}
+
+// Cache fetches remote images and keeps them, with their resized
+// copies, in a directory. The least recently used files are evicted
+// when the directory grows over the maximum size.
+type Cache struct {
+	dir    string
+	client *http.Client
+
+	// Images are locked by the first byte of their key, so that
+	// the same image is fetched and resized only once at a time.
+	locks [256]sync.Mutex
+
+	// mu protects the size of the files in the directory.
+	mu      sync.Mutex
+	used    int64
+	maxSize int64
+}
+
+// NewCache returns a cache storing up to DefaultMaxSize bytes of images
+// in dir, which is created if it doesn't exist.
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     dir,
+		client:  &http.Client{Timeout: fetchTimeout},
+		maxSize: DefaultMaxSize,
+	}
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c.used += f.Size()
+	}
+	return c, nil
+}
+
+// Dir returns the cache directory.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// SetMaxSize changes how many bytes the cache takes,
+// evicting files if needed.
+func (c *Cache) SetMaxSize(maxSize int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	if c.used > c.maxSize {
+		return c.evict()
+	}
+	return nil
+}
+
+// Size returns how many bytes the cached files take.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// Get returns the image at the source URL resized to fit in a
+// size x size square, size being rounded to one of Sizes. When webp
+// is true a WebP image is returned in place of a JPEG or PNG one if
+// it's smaller.
+func (c *Cache) Get(source string, size int, webp bool) (*Image, error) {
+	size = RoundSize(size)
+	sum := sha256.Sum256([]byte(source))
+	key := hex.EncodeToString(sum[:])
+	mu := &c.locks[sum[0]]
+	mu.Lock()
+	defer mu.Unlock()
+
+	var resized image.Image
+	scale := func() (image.Image, error) {
+		if resized == nil {
+			m, err := c.original(key, source)
+			if err != nil {
+				return nil, err
+			}
+			resized = resize(m, size)
+		}
+		return resized, nil
+	}
+
+	best, err := c.variant(key, size, fallbackFormats, scale)
+	if err != nil {
+		return nil, err
+	}
+	if webp {
+		m, err := c.variant(key, size, webpFormats, scale)
+		if err != nil {
+			return nil, err
+		}
+		if len(m.Data) < len(best.Data) {
+			best = m
+		}
+	}
+	return best, nil
+}
+
+// variant returns the image resized to size in one of the formats,
+// encoding it with the first format that suits it if not cached yet.
+func (c *Cache) variant(key string, size int, formats []format, scale func() (image.Image, error)) (*Image, error) {
+	name := key + "-" + strconv.Itoa(size)
+	for _, f := range formats {
+		data, err := c.read(name + f.ext)
+		if err == nil {
+			return newImage(key, size, f, data), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	m, err := scale()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range formats {
+		if !f.suits(m) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.encode(&buf, m); err != nil {
+			return nil, err
+		}
+		if err := c.write(name+f.ext, buf.Bytes()); err != nil {
+			return nil, err
+		}
+		return newImage(key, size, f, buf.Bytes()), nil
+	}
+	return nil, errors.New("avatars: no format suits the image")
+}
+
+func newImage(key string, size int, f format, data []byte) *Image {
+	return &Image{
+		Data:        data,
+		ContentType: f.contentType,
+		ETag:        fmt.Sprintf("%q", key[:16]+"-"+strconv.Itoa(size)+f.ext),
+	}
+}
+
+// original returns the decoded source image, fetching it
+// unless it's in the cache already.
+func (c *Cache) original(key, source string) (image.Image, error) {
+	fileName := key + ".orig"
+	data, err := c.read(fileName)
+	if os.IsNotExist(err) {
+		data, err = c.fetch(source)
+		if err != nil {
+			return nil, err
+		}
+		m, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return m, c.write(fileName, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// fetch downloads the image at the URL.
+func (c *Cache) fetch(source string) ([]byte, error) {
+	resp, err := c.client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("avatars: %s: %s", source, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceBytes {
+		return nil, fmt.Errorf("avatars: %s: image is too large", source)
+	}
+	return data, nil
+}
+
+// decode decodes an image, checking its size first.
+func decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxSourcePixels {
+		return nil, fmt.Errorf("avatars: image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	m, _, err := image.Decode(bytes.NewReader(data))
+	return m, err
+}
+
+// write stores a file atomically, so that concurrent readers
+// never see it half written.
+func (c *Cache) write(fileName string, data []byte) error {
+	f, err := ioutil.TempFile(c.dir, fileName+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.dir, fileName))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	// Failing to evict files doesn't prevent serving this one
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used += int64(len(data))
+	if c.used > c.maxSize {
+		c.evict()
+	}
+	return nil
+}
+
+// read returns the content of a cached file, marking it as used.
+func (c *Cache) read(fileName string) ([]byte, error) {
+	path := filepath.Join(c.dir, fileName)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now(); now.Sub(info.ModTime()) > touchInterval {
+		os.Chtimes(path, now, now)
+	}
+	return data, nil
+}
+
+// files returns the cached files, removing the temporary ones
+// left behind by a crash.
+func (c *Cache) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := entries[:0]
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+		case strings.Contains(entry.Name(), ".tmp"):
+			if time.Since(entry.ModTime()) > fetchTimeout {
+				os.Remove(filepath.Join(c.dir, entry.Name()))
+			}
+		default:
+			files = append(files, entry)
+		}
+	}
+	return files, nil
+}
+
+// evict removes the least recently used files until the cache is
+// back under its maximum size, leaving room for new ones.
+// Must be called with the lock held.
+func (c *Cache) evict() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byModTime(files))
+	c.used = 0
+	for _, f := range files {
+		c.used += f.Size()
+	}
+	target := int64(float64(c.maxSize) * evictRatio)
+	for _, f := range files {
+		if c.used <= target {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, f.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.used -= f.Size()
+	}
+	return nil
+}
+
+// byModTime sorts files from the least recently modified.
+type byModTime []os.FileInfo
+
+// Len returns the length of the slice.
+func (slice byModTime) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice byModTime) Less(i, j int) bool {
+	return slice[i].ModTime().Before(slice[j].ModTime())
+}
+
+// Swap swaps two slice items.
+func (slice byModTime) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
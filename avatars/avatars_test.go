@@ -0,0 +1,224 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package avatars
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClosest(t *testing.T) {
+	sources := Sources{{72, "72"}, {192, "192"}, {512, "512"}}
+	tests := []struct {
+		size int
+		url  string
+	}{
+		{24, "72"},
+		{72, "72"},
+		{96, "192"},
+		{512, "512"},
+		{1024, "512"},
+	}
+	for _, test := range tests {
+		if s, _ := sources.Closest(test.size); s.URL != test.url {
+			t.Errorf("size %d: expected source %q, got %q", test.size, test.url, s.URL)
+		}
+	}
+
+	// Images of unknown size are preferred to smaller ones
+	sources = append(sources, Source{URL: "curated"})
+	if s, _ := sources.Closest(1024); s.URL != "curated" {
+		t.Errorf("expected the image of unknown size, got %q", s.URL)
+	}
+	if _, ok := (Sources{}).Closest(48); ok {
+		t.Errorf("expected no source")
+	}
+}
+
+func TestRoundSize(t *testing.T) {
+	for size, expected := range map[int]int{1: 24, 24: 24, 40: 48, 100: 128, 4000: 1024} {
+		if s := RoundSize(size); s != expected {
+			t.Errorf("size %d: expected %d, got %d", size, expected, s)
+		}
+	}
+}
+
+func TestAcceptsWebP(t *testing.T) {
+	tests := map[string]bool{
+		"":                            false,
+		"image/webp,*/*":              true,
+		"image/avif,image/webp;q=0.9": true,
+		"image/WebP; q=0":             false,
+		"image/*,*/*;q=0.8":           false,
+	}
+	for accept, expected := range tests {
+		if AcceptsWebP(accept) != expected {
+			t.Errorf("%q: expected %v", accept, expected)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+	}
+	// The left half is transparent black, which must not darken the edge
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{})
+		}
+	}
+
+	r := resize(m, 50)
+	if b := r.Bounds(); b.Dx() != 50 || b.Dy() != 25 {
+		t.Fatalf("expected 50x25 pixels, got %v", b)
+	}
+	if c := color.NRGBAModel.Convert(r.At(49, 0)).(color.NRGBA); c != (color.NRGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected color %v", c)
+	}
+	if c := color.NRGBAModel.Convert(r.At(0, 0)).(color.NRGBA); c.A != 0 {
+		t.Errorf("unexpected color %v", c)
+	}
+	if r := resize(m, 400); r != image.Image(m) {
+		t.Errorf("expected small images to be kept as they are")
+	}
+}
+
+// cachedFiles returns the files cached for a source.
+func cachedFiles(t *testing.T, c *Cache, source string) []string {
+	sum := sha256.Sum256([]byte(source))
+	files, err := filepath.Glob(filepath.Join(c.Dir(), hex.EncodeToString(sum[:])+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCacheEviction(t *testing.T) {
+	// Noise, for the images to take some room
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	random := rand.New(rand.NewSource(1))
+	for i := range m.Pix {
+		m.Pix[i] = byte(random.Intn(256))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, m)
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := NewCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sizes are rounded, whoever asks
+	small, err := c.Get(ts.URL+"/a", 33, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounded, err := c.Get(ts.URL+"/a", 48, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.ETag != rounded.ETag || len(cachedFiles(t, c, ts.URL+"/a")) != 2 {
+		t.Errorf("expected sizes to be rounded, got %s and %s", small.ETag, rounded.ETag)
+	}
+	avatarSize := c.Size()
+
+	age := func(source string, d time.Duration) {
+		for _, f := range cachedFiles(t, c, source) {
+			if err := os.Chtimes(f, time.Now().Add(-d), time.Now().Add(-d)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	age(ts.URL+"/a", 3*time.Hour)
+	if _, err := c.Get(ts.URL+"/b", 48, false); err != nil {
+		t.Fatal(err)
+	}
+	age(ts.URL+"/b", 2*time.Hour)
+
+	// Reading the first avatar again makes it more recently used than
+	// the second one, its original image is still the least recently used
+	if _, err := c.Get(ts.URL+"/a", 48, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ts.URL+"/c", 48, false); err != nil {
+		t.Fatal(err)
+	}
+	if size := c.Size(); size != 3*avatarSize {
+		t.Fatalf("expected %d bytes cached, got %d", 3*avatarSize, size)
+	}
+	var kept []string
+	var keptSize int64
+	for _, f := range append(cachedFiles(t, c, ts.URL+"/a"), cachedFiles(t, c, ts.URL+"/c")...) {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(info.ModTime()) < time.Hour {
+			kept = append(kept, f)
+			keptSize += info.Size()
+		}
+	}
+	if err := c.SetMaxSize(int64(float64(keptSize)/evictRatio) + 2); err != nil {
+		t.Fatal(err)
+	}
+	if files := cachedFiles(t, c, ts.URL+"/b"); len(files) != 0 {
+		t.Errorf("expected the least recently used avatar to be evicted, got %v", files)
+	}
+	for _, f := range kept {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("expected the files used recently to be kept: %v", err)
+		}
+	}
+	if len(kept) != 3 || c.Size() != keptSize {
+		t.Errorf("expected %d bytes in %v to be kept, got %d", keptSize, kept, c.Size())
+	}
+
+	// The size is known again when the cache is opened
+	reopened, err := NewCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := reopened.Size(); size != keptSize {
+		t.Errorf("expected %d bytes in the directory, got %d", keptSize, size)
+	}
+}
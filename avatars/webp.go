@@ -0,0 +1,771 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package avatars
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// WebP images are encoded losslessly (VP8L): avatars are small and the
+// lossy encoder would be a lot more code for little gain over JPEG.
+
+const (
+	vp8lSignature = 0x2f
+	vp8lMaxSize   = 1 << 14
+
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	// predictorBits is the log2 of the predictor block size.
+	predictorBits = 4
+
+	// Parameters of the backward references search.
+	minMatch    = 3
+	maxMatch    = 4096
+	maxDistance = 1 << 16
+	chainDepth  = 16
+
+	// numLiteralCodes is the number of green literals, followed
+	// by the length prefixes in the green alphabet.
+	numLiteralCodes  = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+
+	maxCodeLength       = 15
+	maxCodeLengthLength = 7
+)
+
+// codeLengthOrder is the order code length code lengths are written in.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes the image to w as a lossless WebP.
+func EncodeWebP(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errors.New("avatars: invalid WebP image size")
+	}
+	pixels, alpha := argbPixels(m)
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// The decoder undoes transforms in reverse order, so green is
+	// subtracted first and the predictor works on the result
+	subtractGreen(pixels)
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+
+	modes, residuals := predict(pixels, width, height)
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(bw, modes, false)
+
+	bw.write(0, 1)
+	writeImage(bw, residuals, true)
+
+	data := bw.bytes()
+	size := len(data)
+	pad := size & 1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+size+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(size))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if pad != 0 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// argbPixels returns the non-premultiplied pixels of the image
+// and whether any of them is not opaque.
+func argbPixels(m image.Image) ([]uint32, bool) {
+	b := m.Bounds()
+	pixels := make([]uint32, 0, b.Dx()*b.Dy())
+	alpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				alpha = true
+			}
+			pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+	return pixels, alpha
+}
+
+// subSampleSize returns the number of predictor blocks along a side.
+func subSampleSize(size int) int {
+	return (size + 1<<predictorBits - 1) >> predictorBits
+}
+
+// subtractGreen subtracts the green channel from red and blue.
+func subtractGreen(pixels []uint32) {
+	for i, p := range pixels {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		pixels[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// predict picks the predictor mode of each block and returns the modes,
+// as an image with the mode in green, and the prediction residuals.
+func predict(pixels []uint32, width, height int) ([]uint32, []uint32) {
+	blocksX, blocksY := subSampleSize(width), subSampleSize(height)
+	modes := make([]uint32, blocksX*blocksY)
+	residuals := make([]uint32, len(pixels))
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				forEachInBlock(bx, by, width, height, func(x, y int) {
+					cost += residualCost(pixels[y*width+x], predictPixel(pixels, width, x, y, mode))
+				})
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksX+bx] = 0xff000000 | uint32(best)<<8
+			forEachInBlock(bx, by, width, height, func(x, y int) {
+				i := y*width + x
+				residuals[i] = subPixels(pixels[i], predictPixel(pixels, width, x, y, best))
+			})
+		}
+	}
+	return modes, residuals
+}
+
+// forEachInBlock calls f with the coordinates of each pixel of a block.
+func forEachInBlock(bx, by, width, height int, f func(x, y int)) {
+	x0, y0 := bx<<predictorBits, by<<predictorBits
+	x1, y1 := x0+1<<predictorBits, y0+1<<predictorBits
+	if x1 > width {
+		x1 = width
+	}
+	if y1 > height {
+		y1 = height
+	}
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			f(x, y)
+		}
+	}
+}
+
+// residualCost estimates how expensive coding the difference between
+// a pixel and its prediction is.
+func residualCost(p, pred uint32) int {
+	d := subPixels(p, pred)
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := int(int8(d >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+	return cost
+}
+
+// predictPixel returns the prediction of the pixel at x, y.
+// The first row and column have fixed predictors.
+func predictPixel(pixels []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pixels[i-1]
+	case x == 0:
+		return pixels[i-width]
+	}
+	// The top right pixel of the last column is the first one of the row
+	l, t, tl, tr := pixels[i-1], pixels[i-width], pixels[i-width-1], pixels[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPixel(l, t, tl)
+	case 12:
+		return clampAddSubtractFull(l, t, tl)
+	default:
+		return clampAddSubtractHalf(average2(l, t), tl)
+	}
+}
+
+// subPixels subtracts each channel of b from a, modulo 256.
+func subPixels(a, b uint32) uint32 {
+	ag := (a | 0x00ff00ff) - (b & 0xff00ff00)
+	rb := (a | 0xff00ff00) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+// average2 returns the truncated average of each channel.
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// channel returns the channel of p at the given shift.
+func channel(p uint32, shift uint) int {
+	return int(p>>shift) & 0xff
+}
+
+// selectPixel returns whichever of l and t is closest to the
+// gradient estimate l + t - tl.
+func selectPixel(l, t, tl uint32) uint32 {
+	// Manhattan distances to the estimate
+	pl, pt := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		pl += abs(channel(t, shift) - channel(tl, shift))
+		pt += abs(channel(l, shift) - channel(tl, shift))
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+// clampAddSubtractFull returns a + b - c clamped to 0..255 for each channel.
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		p |= uint32(clamp(channel(a, shift)+channel(b, shift)-channel(c, shift))) << shift
+	}
+	return p
+}
+
+// clampAddSubtractHalf returns a + (a - b) / 2 clamped to 0..255 for each channel.
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		ca := channel(a, shift)
+		p |= uint32(clamp(ca+(ca-channel(b, shift))/2)) << shift
+	}
+	return p
+}
+
+func clamp(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// symbol is either a literal pixel or a backward reference.
+type symbol struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// backwardReferences finds repeated runs of pixels, hashing pairs of
+// pixels and walking a bounded chain of earlier occurrences.
+func backwardReferences(pixels []uint32) []symbol {
+	n := len(pixels)
+	symbols := make([]symbol, 0, n)
+	head := make(map[uint64]int)
+	prev := make([]int, n)
+	key := func(i int) uint64 {
+		return uint64(pixels[i])<<32 | uint64(pixels[i+1])
+	}
+	insert := func(i int) {
+		if i+1 >= n {
+			return
+		}
+		k := key(i)
+		if j, ok := head[k]; ok {
+			prev[i] = j
+		} else {
+			prev[i] = -1
+		}
+		head[k] = i
+	}
+	for i := 0; i < n; {
+		length, distance := 0, 0
+		if i+minMatch <= n {
+			if j, ok := head[key(i)]; ok {
+				for depth := 0; j >= 0 && depth < chainDepth && i-j <= maxDistance; depth++ {
+					l := 0
+					for i+l < n && l < maxMatch && pixels[j+l] == pixels[i+l] {
+						l++
+					}
+					if l > length {
+						length, distance = l, i-j
+					}
+					j = prev[j]
+				}
+			}
+		}
+		if length < minMatch {
+			symbols = append(symbols, symbol{pixel: pixels[i]})
+			insert(i)
+			i++
+			continue
+		}
+		symbols = append(symbols, symbol{length: length, distance: distance})
+		for k := 0; k < length; k++ {
+			insert(i + k)
+		}
+		i += length
+	}
+	return symbols
+}
+
+// prefixEncode splits a length or distance into its prefix code
+// and the extra bits following it.
+func prefixEncode(v int) (code int, extraBits uint, extra uint32) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	v--
+	highest := uint(0)
+	for v>>(highest+1) != 0 {
+		highest++
+	}
+	second := (v >> (highest - 1)) & 1
+	extraBits = highest - 1
+	extra = uint32(v) & (1<<extraBits - 1)
+	return int(2*highest) + second, extraBits, extra
+}
+
+// distanceCode maps a distance in pixels to a distance code. Codes up to
+// 120 are reserved for the two-dimensional neighbourhood, which isn't used.
+func distanceCode(distance int) int {
+	return distance + 120
+}
+
+// writeImage entropy codes the pixels: the main image, with a
+// prefix code group for the whole of it, or a transform sub-image.
+func writeImage(bw *bitWriter, pixels []uint32, main bool) {
+	symbols := backwardReferences(pixels)
+
+	green := make([]int, numLiteralCodes+numLengthCodes)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	dist := make([]int, numDistanceCodes)
+	for _, s := range symbols {
+		if s.length == 0 {
+			alpha[s.pixel>>24]++
+			red[(s.pixel>>16)&0xff]++
+			green[(s.pixel>>8)&0xff]++
+			blue[s.pixel&0xff]++
+			continue
+		}
+		code, _, _ := prefixEncode(s.length)
+		green[numLiteralCodes+code]++
+		code, _, _ = prefixEncode(distanceCode(s.distance))
+		dist[code]++
+	}
+
+	// No color cache
+	bw.write(0, 1)
+	if main {
+		// A single prefix code group
+		bw.write(0, 1)
+	}
+	codes := []*huffmanCode{
+		newHuffmanCode(green, maxCodeLength),
+		newHuffmanCode(red, maxCodeLength),
+		newHuffmanCode(blue, maxCodeLength),
+		newHuffmanCode(alpha, maxCodeLength),
+		newHuffmanCode(dist, maxCodeLength),
+	}
+	for _, h := range codes {
+		h.writeHeader(bw)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			codes[0].write(bw, int(s.pixel>>8)&0xff)
+			codes[1].write(bw, int(s.pixel>>16)&0xff)
+			codes[2].write(bw, int(s.pixel)&0xff)
+			codes[3].write(bw, int(s.pixel>>24))
+			continue
+		}
+		code, extraBits, extra := prefixEncode(s.length)
+		codes[0].write(bw, numLiteralCodes+code)
+		bw.write(extra, extraBits)
+		code, extraBits, extra = prefixEncode(distanceCode(s.distance))
+		codes[4].write(bw, code)
+		bw.write(extra, extraBits)
+	}
+}
+
+// bitWriter writes bits least significant first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// bytes returns the written bits, padding the last byte with zeros.
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// huffmanCode is a canonical prefix code.
+type huffmanCode struct {
+	lengths []int
+	// codes are bit reversed, ready for the writer.
+	codes []uint32
+	// symbols used, in increasing order.
+	symbols []int
+}
+
+// newHuffmanCode builds a code from symbol counts,
+// with codes no longer than maxLength bits.
+func newHuffmanCode(counts []int, maxLength int) *huffmanCode {
+	h := &huffmanCode{
+		lengths: huffmanLengths(counts, maxLength),
+		codes:   make([]uint32, len(counts)),
+	}
+	for s, c := range counts {
+		if c > 0 {
+			h.symbols = append(h.symbols, s)
+		}
+	}
+	if len(h.symbols) < 2 {
+		// A single symbol takes no bits at all
+		return h
+	}
+
+	// Assign consecutive codes by length, then symbol
+	var lengthCount [maxCodeLength + 2]uint32
+	for _, l := range h.lengths {
+		lengthCount[l]++
+	}
+	lengthCount[0] = 0
+	var next [maxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + lengthCount[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range h.lengths {
+		if l == 0 {
+			continue
+		}
+		h.codes[s] = reverseBits(next[l], uint(l))
+		next[l]++
+	}
+	return h
+}
+
+// reverseBits reverses the n lowest bits of v.
+func reverseBits(v uint32, n uint) uint32 {
+	r := uint32(0)
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+func (h *huffmanCode) write(bw *bitWriter, s int) {
+	if len(h.symbols) < 2 {
+		return
+	}
+	bw.write(h.codes[s], uint(h.lengths[s]))
+}
+
+// writeHeader writes the code lengths, with a simple code when
+// there are at most two symbols that fit in 8 bits.
+func (h *huffmanCode) writeHeader(bw *bitWriter) {
+	symbols := h.symbols
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+	if len(symbols) <= 2 && symbols[len(symbols)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+		}
+		return
+	}
+
+	lengths := h.lengths
+	if len(h.symbols) == 1 {
+		// Any length will do, as long as it's the only one
+		lengths = make([]int, len(h.lengths))
+		lengths[h.symbols[0]] = 1
+	}
+
+	bw.write(0, 1)
+	tokens := codeLengthTokens(lengths)
+	counts := make([]int, len(codeLengthOrder))
+	for _, t := range tokens {
+		counts[t.code]++
+	}
+	lengthCode := newHuffmanCode(counts, maxCodeLengthLength)
+	lengthCodeLengths := lengthCode.lengths
+	if len(lengthCode.symbols) == 1 {
+		lengthCodeLengths = make([]int, len(counts))
+		lengthCodeLengths[lengthCode.symbols[0]] = 1
+	}
+	n := len(codeLengthOrder)
+	for n > 4 && lengthCodeLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, c := range codeLengthOrder[:n] {
+		bw.write(uint32(lengthCodeLengths[c]), 3)
+	}
+	// Lengths are given for the whole alphabet
+	bw.write(0, 1)
+	for _, t := range tokens {
+		lengthCode.write(bw, t.code)
+		bw.write(t.extra, t.extraBits)
+	}
+}
+
+// codeLengthToken is a code length, or a run of them.
+type codeLengthToken struct {
+	code      int
+	extraBits uint
+	extra     uint32
+}
+
+// codeLengthTokens run length encodes code lengths: 16 repeats the
+// previous length 3 to 6 times, 17 and 18 write 3 to 10 and 11 to 138 zeros.
+func codeLengthTokens(lengths []int) []codeLengthToken {
+	var tokens []codeLengthToken
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run > 0 {
+				switch {
+				case run >= 11:
+					r := run
+					if r > 138 {
+						r = 138
+					}
+					tokens = append(tokens, codeLengthToken{18, 7, uint32(r - 11)})
+					run -= r
+				case run >= 3:
+					tokens = append(tokens, codeLengthToken{17, 3, uint32(run - 3)})
+					run = 0
+				default:
+					tokens = append(tokens, codeLengthToken{code: 0})
+					run--
+				}
+			}
+			continue
+		}
+		tokens = append(tokens, codeLengthToken{code: l})
+		run--
+		for run > 0 {
+			if run < 3 {
+				tokens = append(tokens, codeLengthToken{code: l})
+				run--
+				continue
+			}
+			r := run
+			if r > 6 {
+				r = 6
+			}
+			tokens = append(tokens, codeLengthToken{16, 2, uint32(r - 3)})
+			run -= r
+		}
+	}
+	return tokens
+}
+
+// huffmanLengths returns the code length of each symbol. Counts are
+// flattened until the longest code fits in maxLength bits.
+func huffmanLengths(counts []int, maxLength int) []int {
+	weights := append([]int(nil), counts...)
+	for {
+		lengths := treeLengths(weights)
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+		for i, w := range weights {
+			if w > 0 {
+				weights[i] = (w + 1) / 2
+			}
+		}
+	}
+}
+
+// treeNode is a node of a Huffman tree; leaves have no children.
+type treeNode struct {
+	weight      int
+	symbol      int
+	left, right *treeNode
+}
+
+// treeLengths returns the depth of each symbol in a Huffman tree,
+// built by merging the two lightest nodes from a queue of leaves
+// sorted by weight and a queue of merged nodes.
+func treeLengths(weights []int) []int {
+	lengths := make([]int, len(weights))
+	var leaves []*treeNode
+	for s, w := range weights {
+		if w > 0 {
+			leaves = append(leaves, &treeNode{weight: w, symbol: s})
+		}
+	}
+	if len(leaves) < 2 {
+		for _, n := range leaves {
+			lengths[n.symbol] = 1
+		}
+		return lengths
+	}
+	sort.Sort(treeNodes(leaves))
+
+	var merged []*treeNode
+	lightest := func() *treeNode {
+		var n *treeNode
+		if len(merged) == 0 || (len(leaves) > 0 && leaves[0].weight <= merged[0].weight) {
+			n, leaves = leaves[0], leaves[1:]
+		} else {
+			n, merged = merged[0], merged[1:]
+		}
+		return n
+	}
+	for len(leaves)+len(merged) > 1 {
+		a := lightest()
+		b := lightest()
+		merged = append(merged, &treeNode{weight: a.weight + b.weight, left: a, right: b})
+	}
+
+	var walk func(n *treeNode, depth int)
+	walk = func(n *treeNode, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(merged[0], 0)
+	return lengths
+}
+
+// treeNodes sorts Huffman tree nodes by weight, then symbol.
+type treeNodes []*treeNode
+
+// Len returns the length of the slice.
+func (n treeNodes) Len() int {
+	return len(n)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (n treeNodes) Less(i, j int) bool {
+	if n[i].weight != n[j].weight {
+		return n[i].weight < n[j].weight
+	}
+	return n[i].symbol < n[j].symbol
+}
+
+// Swap swaps two slice items.
+func (n treeNodes) Swap(i, j int) {
+	n[i], n[j] = n[j], n[i]
+}
@@ -0,0 +1,204 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package avatars
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestHuffmanLengths(t *testing.T) {
+	// Fibonacci counts make the deepest possible tree
+	counts := make([]int, 30)
+	a, b := 1, 1
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(counts, maxCodeLength)
+
+	// A complete code fills the Kraft inequality exactly
+	kraft := 0
+	for _, l := range lengths {
+		if l < 1 || l > maxCodeLength {
+			t.Fatalf("invalid code length %d", l)
+		}
+		kraft += 1 << uint(maxCodeLength-l)
+	}
+	if kraft != 1<<maxCodeLength {
+		t.Errorf("incomplete code, Kraft sum %d", kraft)
+	}
+}
+
+func TestPrefixEncode(t *testing.T) {
+	// Decoding as described by the specification must give the value back
+	for v := 1; v <= 1<<20; v++ {
+		code, extraBits, extra := prefixEncode(v)
+		decoded := code + 1
+		if code >= 4 {
+			bits := uint(code-2) >> 1
+			if bits != extraBits {
+				t.Fatalf("%d: expected %d extra bits, got %d", v, bits, extraBits)
+			}
+			decoded = (2+code&1)<<bits + int(extra) + 1
+		}
+		if decoded != v {
+			t.Fatalf("%d: decoded as %d", v, decoded)
+		}
+	}
+}
+
+func TestCodeLengthTokens(t *testing.T) {
+	lengths := []int{3, 3, 3, 3, 3, 3, 3, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0}
+	var decoded []int
+	prev := 8
+	for _, token := range codeLengthTokens(lengths) {
+		switch token.code {
+		case 16:
+			for i := 0; i < int(token.extra)+3; i++ {
+				decoded = append(decoded, prev)
+			}
+		case 17:
+			decoded = append(decoded, make([]int, token.extra+3)...)
+		case 18:
+			decoded = append(decoded, make([]int, token.extra+11)...)
+		default:
+			decoded = append(decoded, token.code)
+			if token.code != 0 {
+				prev = token.code
+			}
+		}
+	}
+	if len(decoded) != len(lengths) {
+		t.Fatalf("expected %d lengths, got %d", len(lengths), len(decoded))
+	}
+	for i := range lengths {
+		if decoded[i] != lengths[i] {
+			t.Fatalf("length %d: expected %d, got %d", i, lengths[i], decoded[i])
+		}
+	}
+}
+
+func TestEncodeWebPHeader(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	m.SetNRGBA(3, 4, color.NRGBA{1, 2, 3, 4})
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WEBPVP8L" {
+		t.Fatalf("unexpected header %q", data[:16])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Errorf("RIFF size %d for %d bytes", size, len(data))
+	}
+	if data[20] != vp8lSignature {
+		t.Errorf("unexpected signature %#x", data[20])
+	}
+	bits := binary.LittleEndian.Uint32(data[21:])
+	width, height, alpha := bits&0x3fff+1, (bits>>14)&0x3fff+1, bits>>28&1
+	if width != 33 || height != 17 || alpha != 1 {
+		t.Errorf("unexpected size %dx%d, alpha %d", width, height, alpha)
+	}
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	tests := []struct {
+		width, height int
+		alpha         bool
+	}{
+		{1, 1, false},
+		{1, 1, true},
+		{33, 17, true},
+		{512, 512, false},
+		{512, 512, true},
+	}
+	for _, test := range tests {
+		m := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+		for y := 0; y < test.height; y++ {
+			for x := 0; x < test.width; x++ {
+				// Gradients with noise, as in photos
+				c := color.NRGBA{uint8(x + random.Intn(8)), uint8(y), uint8(x ^ y), 0xff}
+				if test.alpha {
+					c.A = uint8(random.Intn(256))
+				}
+				m.SetNRGBA(x, y, c)
+			}
+		}
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", test.width, test.height, err)
+		}
+		if decoded.Bounds() != m.Bounds() {
+			t.Fatalf("%dx%d: decoded as %v", test.width, test.height, decoded.Bounds())
+		}
+		for y := 0; y < test.height; y++ {
+			for x := 0; x < test.width; x++ {
+				want := m.NRGBAAt(x, y)
+				got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+				if got != want {
+					t.Fatalf("%dx%d: pixel %d,%d is %v, expected %v", test.width, test.height, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPredictorModes(t *testing.T) {
+	// Predicting from the decoded neighbours gives the pixels back
+	pixels := []uint32{
+		0xff102030, 0xff112233, 0x80405060, 0x00000000,
+		0xffffffff, 0xff000000, 0x7f7f7f7f, 0x01020304,
+		0x10203040, 0xfffefdfc, 0x80808080, 0xff0000ff,
+	}
+	width := 4
+	for mode := 0; mode < 14; mode++ {
+		for i, p := range pixels {
+			pred := predictPixel(pixels, width, i%width, i/width, mode)
+			residual := subPixels(p, pred)
+			if addPixels(residual, pred) != p {
+				t.Fatalf("mode %d, pixel %d: residual %#x of %#x doesn't add up", mode, i, residual, p)
+			}
+		}
+	}
+}
+
+// addPixels adds each channel of a and b, modulo 256.
+func addPixels(a, b uint32) uint32 {
+	ag := (a & 0xff00ff00) + (b & 0xff00ff00)
+	rb := (a & 0x00ff00ff) + (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
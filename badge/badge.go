@@ -0,0 +1,148 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package badge renders shields-style SVG badges.
+package badge
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"unicode"
+)
+
+// Colors of the badges, by name.
+var Colors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"grey":        "#555",
+	"lightgrey":   "#9f9f9f",
+}
+
+// labelColor is the background of the label.
+const labelColor = "#555"
+
+// padding is the horizontal space around the texts.
+const padding = 5
+
+// hexColor matches the colors given as #rgb or #rrggbb.
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Badge is a label followed by a message on a colored background.
+type Badge struct {
+	Label   string
+	Message string
+	// Color is the background of the message, a name of Colors
+	// or a hex color such as #4c1.
+	Color string
+}
+
+// color returns the background of the message, lightgrey if unknown.
+func (b Badge) color() string {
+	if c, ok := Colors[b.Color]; ok {
+		return c
+	}
+	if hexColor.MatchString(b.Color) {
+		return b.Color
+	}
+	return Colors["lightgrey"]
+}
+
+// charWidths are the advance widths of the printable ASCII characters,
+// from space to tilde, in Verdana at 11px, the font of the badges.
+var charWidths = [...]float64{
+	3.87, 4.33, 5.05, 9.00, 6.99, 11.84, 7.99, 2.95, 4.99, 4.99, 6.99, 9.00, 4.00, 4.99, 4.00, 4.99,
+	6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 4.99, 4.99, 9.00, 9.00, 9.00, 5.99,
+	11.00, 7.52, 7.54, 7.68, 8.48, 6.96, 6.32, 8.53, 8.27, 4.61, 5.00, 7.62, 6.12, 9.27, 8.23, 8.66,
+	6.63, 8.66, 7.65, 7.52, 6.78, 8.05, 7.52, 10.89, 7.54, 6.77, 7.54, 4.99, 4.99, 4.99, 9.00, 6.99,
+	6.99, 6.61, 6.85, 5.73, 6.85, 6.55, 3.87, 6.85, 6.96, 3.02, 3.79, 6.51, 3.02, 10.70, 6.96, 6.68,
+	6.85, 6.85, 4.69, 5.73, 4.33, 6.96, 6.51, 9.00, 6.51, 6.51, 5.78, 6.98, 4.99, 6.98, 9.00,
+}
+
+// Widths of the characters missing from the table.
+const (
+	defaultWidth = 7.0
+	wideWidth    = 11.0
+)
+
+// TextWidth estimates the width of a text in pixels, as browsers
+// render it in the badges, without needing the font.
+func TextWidth(s string) float64 {
+	width := 0.0
+	for _, r := range s {
+		switch {
+		case r >= ' ' && r <= '~':
+			width += charWidths[r-' ']
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hangul, r) ||
+			unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			width += wideWidth
+		case unicode.IsPrint(r):
+			width += defaultWidth
+		}
+	}
+	return width
+}
+
+// SVG renders the badge.
+func (b Badge) SVG() []byte {
+	labelWidth := int(math.Ceil(TextWidth(b.Label))) + 2*padding
+	messageWidth := int(math.Ceil(TextWidth(b.Message))) + 2*padding
+	width := labelWidth + messageWidth
+	label, message := escape(b.Label), escape(b.Message)
+	title := label + ": " + message
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s">`, width, title)
+	fmt.Fprintf(&buf, `<title>%s</title>`, title)
+	buf.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&buf, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&buf, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="%s"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`,
+		labelWidth, labelColor, labelWidth, messageWidth, b.color(), width)
+	buf.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	for _, text := range []struct {
+		x    float64
+		text string
+	}{
+		{float64(labelWidth) / 2, label},
+		{float64(labelWidth) + float64(messageWidth)/2, message},
+	} {
+		// A shadow below the text
+		fmt.Fprintf(&buf, `<text x="%g" y="15" fill="#010101" fill-opacity=".3">%s</text>`, text.x, text.text)
+		fmt.Fprintf(&buf, `<text x="%g" y="14">%s</text>`, text.x, text.text)
+	}
+	buf.WriteString(`</g></svg>`)
+	return buf.Bytes()
+}
+
+// escape escapes a text for XML.
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
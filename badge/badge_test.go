@@ -0,0 +1,98 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package badge
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	if w := TextWidth("chat"); math.Abs(w-23.63) > 0.01 {
+		t.Errorf("expected chat to be 23.63px wide, got %g", w)
+	}
+	if TextWidth("WWW") <= TextWidth("iii") {
+		t.Errorf("expected wide letters to be wider")
+	}
+	if TextWidth("日本") != 2*wideWidth {
+		t.Errorf("expected ideographs to be wide")
+	}
+	if TextWidth("\x00\t") != 0 {
+		t.Errorf("expected control characters to take no space")
+	}
+}
+
+// svgTexts parses the badge and returns its texts.
+func svgTexts(t *testing.T, svg []byte) []string {
+	var texts []string
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return texts
+		}
+		if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, svg)
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			inText = tok.Name.Local == "text"
+		case xml.CharData:
+			if inText {
+				texts = append(texts, string(tok))
+			}
+		case xml.EndElement:
+			inText = false
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	svg := Badge{Label: "chat", Message: "42/310 online", Color: "brightgreen"}.SVG()
+	texts := svgTexts(t, svg)
+	if len(texts) != 4 || texts[1] != "chat" || texts[3] != "42/310 online" {
+		t.Errorf("unexpected texts %q", texts)
+	}
+	if !bytes.Contains(svg, []byte(`fill="#4c1"`)) {
+		t.Errorf("expected the message to be green")
+	}
+	// 24px and 77px of text, with padding
+	if !bytes.Contains(svg, []byte(`width="121"`)) {
+		t.Errorf("unexpected width:\n%s", svg)
+	}
+
+	svg = Badge{Label: `<script>"`, Message: "a & b", Color: `red" onload="alert(1)`}.SVG()
+	texts = svgTexts(t, svg)
+	if texts[1] != `<script>"` || texts[3] != "a & b" {
+		t.Errorf("expected texts to be escaped, got %q", texts)
+	}
+	if strings.Contains(string(svg), "onload") {
+		t.Errorf("expected unknown colors to be ignored:\n%s", svg)
+	}
+}
@@ -0,0 +1,181 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	server "github.com/lirios/website/server"
+)
+
+// defaultSettingsFileName is read, if it exists, when no file is given.
+const defaultSettingsFileName = "./config.ini"
+
+// override is a setting given on the command line.
+type override struct {
+	variable server.Variable
+	value    string
+}
+
+// settingsLoader reads the settings from, in order of precedence,
+// the command line, the environment, the settings file and the defaults.
+type settingsLoader struct {
+	fileName  string
+	optional  bool
+	overrides []override
+}
+
+// load reads and validates the settings.
+func (l *settingsLoader) load() (*server.Settings, error) {
+	settings, problems := l.check()
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return settings, nil
+}
+
+// check reads the settings, returning all the problems found
+// along with where the offending settings were given.
+func (l *settingsLoader) check() (*server.Settings, server.Problems) {
+	var problems server.Problems
+	settings := server.Defaults()
+	positions, err := settings.ReadFile(l.fileName)
+	switch err := err.(type) {
+	case nil:
+	case server.Problems:
+		problems = append(problems, err...)
+	default:
+		if !l.optional || !os.IsNotExist(err) {
+			problems = append(problems, server.Problem{Message: err.Error()})
+		}
+	}
+	if positions == nil {
+		positions = server.Positions{}
+	}
+
+	for _, v := range server.Variables() {
+		if _, ok := os.LookupEnv(v.EnvName()); ok {
+			positions.Override(v, "$"+v.EnvName())
+		}
+	}
+	if err := settings.SetFromEnv(os.LookupEnv); err != nil {
+		problems = append(problems, err.(server.Problems)...)
+	}
+
+	// Secrets given as flags replace those given otherwise
+	for _, o := range l.overrides {
+		settings.ClearSecret(o.variable.Section, o.variable.Name)
+	}
+	for _, o := range l.overrides {
+		pos := "-" + o.variable.FlagName()
+		positions.Override(o.variable, pos)
+		if err := settings.Set(o.variable.Section, o.variable.Name, o.value); err != nil {
+			problems = append(problems, server.Problem{
+				Pos:     pos,
+				Section: o.variable.Section,
+				Name:    o.variable.Name,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	problems = append(problems, positions.Locate(settings.ReadSecrets())...)
+	overridePositions, err := settings.ReadOverrides()
+	positions.Merge(overridePositions)
+	if err != nil {
+		problems = append(problems, positions.Locate(err.(server.Problems))...)
+	}
+	problems = append(problems, positions.Locate(settings.Check())...)
+	return settings, problems
+}
+
+// checkConfig implements the check-config command, printing the
+// problems found in the settings and returning the exit status.
+func checkConfig(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s check-config [flags] <file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	loader := parseFlags(flags, args)
+	if loader.optional || flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	_, problems := loader.check()
+	for _, problem := range problems {
+		fmt.Fprintln(out, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// settingFlag is a command line flag overriding a setting.
+type settingFlag struct {
+	loader   *settingsLoader
+	variable server.Variable
+}
+
+func (f settingFlag) String() string {
+	return ""
+}
+
+func (f settingFlag) Set(value string) error {
+	// Catch invalid values while parsing the command line
+	if err := server.Defaults().Set(f.variable.Section, f.variable.Name, value); err != nil {
+		return err
+	}
+	f.loader.overrides = append(f.loader.overrides, override{f.variable, value})
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool {
+	return f.variable.IsBool()
+}
+
+// parseFlags parses the command line, returning how to load the settings.
+// The settings file can also be passed as the only argument.
+func parseFlags(flags *flag.FlagSet, args []string) *settingsLoader {
+	loader := &settingsLoader{}
+	flags.StringVar(&loader.fileName, "config", "", "settings `file` (default "+defaultSettingsFileName+" if it exists)")
+	for _, v := range server.Variables() {
+		flags.Var(settingFlag{loader, v}, v.FlagName(), "set "+v.Name+" in section "+v.Section+", also $"+v.EnvName())
+	}
+	flags.Parse(args)
+
+	if loader.fileName == "" {
+		loader.fileName = flags.Arg(0)
+	}
+	if loader.fileName == "" {
+		loader.fileName = defaultSettingsFileName
+		loader.optional = true
+	}
+	return loader
+}
@@ -0,0 +1,244 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package events broadcasts server-sent events to the subscribers.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the broker limits.
+const (
+	DefaultMaxSubscribers = 500
+	DefaultHistorySize    = 256
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind
+// before being dropped. Dropped clients reconnect and catch up
+// with the history.
+const subscriberBuffer = 64
+
+var (
+	// ErrTooManySubscribers is returned when the subscribers limit is reached.
+	ErrTooManySubscribers = errors.New("events: too many subscribers")
+
+	// ErrClosed is returned when subscribing to a closed broker.
+	ErrClosed = errors.New("events: broker closed")
+)
+
+// Event is a server-sent event.
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// WriteTo writes the event in the text/event-stream format.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	if e.ID != 0 {
+		b.WriteString("id: " + strconv.FormatUint(e.ID, 10) + "\n")
+	}
+	if e.Type != "" {
+		b.WriteString("event: " + e.Type + "\n")
+	}
+	for _, line := range bytes.Split(e.Data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.WriteTo(w)
+}
+
+// Broker sends the events published to every subscriber, keeping
+// the latest ones so that reconnecting clients can catch up.
+type Broker struct {
+	mu             sync.Mutex
+	maxSubscribers int
+	historySize    int
+	history        []Event
+	lastID         uint64
+	subscribers    map[*Subscription]struct{}
+	closed         bool
+}
+
+// NewBroker returns a broker accepting up to maxSubscribers and
+// keeping historySize events.
+func NewBroker(maxSubscribers, historySize int) *Broker {
+	if maxSubscribers <= 0 {
+		maxSubscribers = DefaultMaxSubscribers
+	}
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Broker{
+		maxSubscribers: maxSubscribers,
+		historySize:    historySize,
+		// IDs start from the clock, so that the ones of
+		// a previous run aren't mistaken for recent ones
+		lastID:      uint64(time.Now().UnixNano()),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// SetMaxSubscribers changes the subscribers limit, which
+// doesn't disconnect the subscribers in excess.
+func (b *Broker) SetMaxSubscribers(n int) {
+	if n <= 0 {
+		n = DefaultMaxSubscribers
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxSubscribers = n
+}
+
+// Subscribers returns the number of subscribers.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Publish sends an event with the JSON encoding of data to the subscribers.
+func (b *Broker) Publish(eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	b.lastID++
+	e := Event{ID: b.lastID, Type: eventType, Data: encoded}
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = append([]Event(nil), b.history[len(b.history)-b.historySize:]...)
+	}
+	for s := range b.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			b.drop(s)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a subscription to the events published from now on.
+// The events following lastID, the ID of the last event received by
+// a reconnecting client, are replayed if they are still known.
+func (b *Broker) Subscribe(lastID string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if len(b.subscribers) >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	s := &Subscription{broker: b, events: make(chan Event, subscriberBuffer)}
+	if lastID != "" {
+		s.Replay, s.Missed = b.since(lastID)
+	}
+	b.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+// since returns the events following the one with the given ID,
+// and whether some were forgotten or the ID is not known.
+func (b *Broker) since(lastID string) ([]Event, bool) {
+	id, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil || id > b.lastID {
+		return nil, true
+	}
+	first := b.lastID + 1
+	if len(b.history) > 0 {
+		first = b.history[0].ID
+	}
+	if id+1 < first {
+		return nil, true
+	}
+	var replay []Event
+	for _, e := range b.history {
+		if e.ID > id {
+			replay = append(replay, e)
+		}
+	}
+	return replay, false
+}
+
+// drop removes a subscriber, closing its channel.
+// Must be called with the lock held.
+func (b *Broker) drop(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Close disconnects the subscribers and refuses new ones.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.drop(s)
+	}
+}
+
+// Subscription receives the events of a broker.
+type Subscription struct {
+	broker *Broker
+	events chan Event
+
+	// Replay are the events missed since the last event ID.
+	Replay []Event
+	// Missed is true when the events since the last event ID
+	// are not known, so the client must start over.
+	Missed bool
+}
+
+// Events returns the channel receiving the events. It's closed when
+// the subscriber falls too far behind or the broker is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+}
@@ -0,0 +1,115 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package events
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	var b bytes.Buffer
+	Event{ID: 7, Type: "presence", Data: []byte("{\"a\":1}\n{}")}.WriteTo(&b)
+	expected := "id: 7\nevent: presence\ndata: {\"a\":1}\ndata: {}\n\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := NewBroker(2, 0)
+	s1, _ := b.Subscribe("")
+	s2, _ := b.Subscribe("")
+	if _, err := b.Subscribe(""); err != ErrTooManySubscribers {
+		t.Errorf("expected the subscribers to be limited, got %v", err)
+	}
+
+	b.Publish("join", map[string]string{"name": "alice"})
+	for _, s := range []*Subscription{s1, s2} {
+		e := <-s.Events()
+		if e.Type != "join" || string(e.Data) != `{"name":"alice"}` {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+
+	s1.Close()
+	if n := b.Subscribers(); n != 1 {
+		t.Errorf("expected 1 subscriber, got %d", n)
+	}
+	b.Close()
+	if _, ok := <-s2.Events(); ok {
+		t.Errorf("expected closing the broker to end the subscriptions")
+	}
+	if _, err := b.Subscribe(""); err != ErrClosed {
+		t.Errorf("expected subscribing to fail, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBroker(0, 3)
+	s, _ := b.Subscribe("")
+	for i := 0; i < 5; i++ {
+		b.Publish("presence", i)
+	}
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, strconv.FormatUint((<-s.Events()).ID, 10))
+	}
+
+	s, _ = b.Subscribe(ids[2])
+	if s.Missed || len(s.Replay) != 2 || string(s.Replay[0].Data) != "3" {
+		t.Errorf("expected the last 2 events, got %+v", s)
+	}
+	s, _ = b.Subscribe(ids[4])
+	if s.Missed || len(s.Replay) != 0 {
+		t.Errorf("expected nothing to replay, got %+v", s)
+	}
+
+	// Only 3 events are kept
+	s, _ = b.Subscribe(ids[0])
+	if !s.Missed {
+		t.Errorf("expected forgotten events to be reported")
+	}
+	for _, id := range []string{"bogus", "99999999999999999999"} {
+		if s, _ = b.Subscribe(id); !s.Missed {
+			t.Errorf("%s: expected unknown IDs to be reported", id)
+		}
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := NewBroker(0, 0)
+	s, _ := b.Subscribe("")
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish("presence", i)
+	}
+	n := 0
+	for range s.Events() {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered events before being dropped, got %d", subscriberBuffer, n)
+	}
+}
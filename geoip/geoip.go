@@ -0,0 +1,159 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package geoip locates IP addresses using a local database.
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ipRange is a range of addresses located in a country.
+type ipRange struct {
+	start, end net.IP
+	country    string
+}
+
+// Database maps IP addresses to countries.
+type Database struct {
+	ranges []ipRange
+}
+
+// Open reads a database from a CSV file, where each line holds the first
+// and last address of a range followed by the ISO 3166 country code, as in
+// the free country databases distributed by DB-IP and IP2Location.
+// Addresses may be written in the usual notation or as decimal numbers.
+func Open(fileName string) (*Database, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return db, nil
+}
+
+// Read reads a database in the format described by Open.
+func Read(r io.Reader) (*Database, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	db := &Database{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 fields", line)
+		}
+		start, end := parseIP(record[0]), parseIP(record[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid address range", line)
+		}
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if len(country) != 2 {
+			// Unassigned ranges are marked with "-" or "ZZ"
+			continue
+		}
+		db.ranges = append(db.ranges, ipRange{start, end, country})
+	}
+
+	sort.Sort(byStart(db.ranges))
+	return db, nil
+}
+
+// parseIP parses an address in the usual notation or as a decimal number,
+// returning it in its 16 bytes form.
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16()
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return nil
+	}
+	if n.BitLen() <= 32 {
+		ip := make(net.IP, net.IPv4len)
+		b := n.Bytes()
+		copy(ip[net.IPv4len-len(b):], b)
+		return ip.To16()
+	}
+	ip := make(net.IP, net.IPv6len)
+	b := n.Bytes()
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
+
+// Country returns the ISO 3166 code of the country where an address
+// is located, or an empty string when unknown.
+func (db *Database) Country(ip net.IP) string {
+	if db == nil || ip == nil {
+		return ""
+	}
+	ip = ip.To16()
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	})
+	if i == 0 {
+		return ""
+	}
+	r := db.ranges[i-1]
+	if bytes.Compare(ip, r.end) > 0 {
+		return ""
+	}
+	return r.country
+}
+
+// byStart sorts ranges by their first address.
+type byStart []ipRange
+
+// Len returns the length of the slice.
+func (slice byStart) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice byStart) Less(i, j int) bool {
+	return bytes.Compare(slice[i].start, slice[j].start) < 0
+}
+
+// Swap swaps two slice items.
+func (slice byStart) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
@@ -0,0 +1,72 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package geoip
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testDatabase = `# start,end,country
+"1.0.0.0","1.0.0.255","AU"
+"2.16.0.0","2.16.255.255","IT"
+"16777472","16777727","CN"
+"3.0.0.0","3.0.0.255","-"
+"2001:db8::","2001:db8::ffff","DE"
+`
+
+func TestCountry(t *testing.T) {
+	db, err := Read(strings.NewReader(testDatabase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip, country, continent string
+	}{
+		{"1.0.0.1", "AU", "OC"},
+		{"2.16.1.1", "IT", "EU"},
+		{"1.0.1.10", "CN", "AS"},
+		{"3.0.0.1", "", ""},
+		{"9.9.9.9", "", ""},
+		{"2001:db8::1", "DE", "EU"},
+		{"2001:db8::1:0", "", ""},
+	}
+	for _, test := range tests {
+		country := db.Country(net.ParseIP(test.ip))
+		if country != test.country {
+			t.Errorf("expected country %q for %s, got %q", test.country, test.ip, country)
+		}
+		if continent := Continent(country); continent != test.continent {
+			t.Errorf("expected continent %q for %s, got %q", test.continent, test.ip, continent)
+		}
+	}
+}
+
+func TestNilDatabase(t *testing.T) {
+	var db *Database
+	if country := db.Country(net.ParseIP("1.1.1.1")); country != "" {
+		t.Errorf("expected no country, got %q", country)
+	}
+}
@@ -0,0 +1,100 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package invite protects the community invitations from abuse.
+package invite
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// Reasons for rejecting an invitation.
+var (
+	ErrInvalidEmail    = errors.New("invalid email address")
+	ErrDisposableEmail = errors.New("disposable email addresses are not accepted")
+)
+
+// maxEmailLength is the longest address SMTP allows.
+const maxEmailLength = 254
+
+// DisposableDomains are well-known disposable email services.
+var DisposableDomains = []string{
+	"10minutemail.com",
+	"discard.email",
+	"dispostable.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"grr.la",
+	"guerrillamail.com",
+	"guerrillamailblock.com",
+	"mailcatch.com",
+	"maildrop.cc",
+	"mailinator.com",
+	"mailnesia.com",
+	"mintemail.com",
+	"mohmal.com",
+	"sharklasers.com",
+	"spamgourmet.com",
+	"temp-mail.org",
+	"tempmail.com",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// ParseEmail validates a bare email address, such as alice@example.org,
+// and returns it with the domain lowercased.
+func ParseEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+	if local == "" || !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") ||
+		strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", ErrInvalidEmail
+	}
+	return local + "@" + domain, nil
+}
+
+// CheckDomain rejects addresses of the blocked domains and their
+// subdomains, along with the disposable ones.
+func CheckDomain(email string, blocked []string) error {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	for _, list := range [][]string{DisposableDomains, blocked} {
+		for _, b := range list {
+			b = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(b), "@"))
+			if b != "" && (domain == b || strings.HasSuffix(domain, "."+b)) {
+				return ErrDisposableEmail
+			}
+		}
+	}
+	return nil
+}
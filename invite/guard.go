@@ -0,0 +1,173 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package invite
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChallengeTTL is how long a proof of work challenge can be solved for.
+const ChallengeTTL = 10 * time.Minute
+
+// MaxDifficulty is the highest proof of work difficulty, in bits.
+const MaxDifficulty = 32
+
+// ErrInvalidProof is returned for a wrong, expired or reused proof of work.
+var ErrInvalidProof = errors.New("invalid proof of work")
+
+// Guard limits the invitations asked by each client and issues
+// the proof of work challenges.
+type Guard struct {
+	key []byte
+
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+	solved   map[string]time.Time
+	cleaned  time.Time
+}
+
+// NewGuard returns a guard with a new key for the challenges,
+// so challenges issued before a restart are not accepted.
+func NewGuard() *Guard {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &Guard{
+		key:      key,
+		attempts: make(map[string][]time.Time),
+		solved:   make(map[string]time.Time),
+	}
+}
+
+// Allow records an attempt from a client and returns whether it's
+// within limit attempts per window, or else how long to wait.
+func (g *Guard) Allow(client string, limit int, window time.Duration, now time.Time) (bool, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.clean(window, now)
+
+	var recent []time.Time
+	for _, t := range g.attempts[client] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		g.attempts[client] = recent
+		return false, window - now.Sub(recent[0])
+	}
+	g.attempts[client] = append(recent, now)
+	return true, 0
+}
+
+// clean forgets the attempts and solved challenges too old to matter,
+// once in a while. Must be called with the lock held.
+func (g *Guard) clean(window time.Duration, now time.Time) {
+	if now.Sub(g.cleaned) < time.Minute {
+		return
+	}
+	g.cleaned = now
+	for client, attempts := range g.attempts {
+		if len(attempts) == 0 || now.Sub(attempts[len(attempts)-1]) >= window {
+			delete(g.attempts, client)
+		}
+	}
+	for challenge, issued := range g.solved {
+		if now.Sub(issued) >= ChallengeTTL {
+			delete(g.solved, challenge)
+		}
+	}
+}
+
+// Challenge returns a new proof of work challenge. Challenges carry
+// their issue time and are signed, so that they needn't be stored.
+func (g *Guard) Challenge(now time.Time) string {
+	data := make([]byte, 24)
+	binary.BigEndian.PutUint64(data, uint64(now.Unix()))
+	if _, err := rand.Read(data[8:]); err != nil {
+		panic(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + g.sign(encoded)
+}
+
+func (g *Guard) sign(data string) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Verify checks that the SHA-256 hash of challenge:nonce starts with
+// difficulty zero bits, for a challenge issued less than ChallengeTTL
+// ago and not solved before.
+func (g *Guard) Verify(challenge, nonce string, difficulty int, now time.Time) error {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(g.sign(parts[0]))) {
+		return ErrInvalidProof
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(data) != 24 {
+		return ErrInvalidProof
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	if now.Sub(issued) >= ChallengeTTL || issued.After(now) {
+		return ErrInvalidProof
+	}
+	if LeadingZeros(sha256.Sum256([]byte(challenge+":"+nonce))) < difficulty {
+		return ErrInvalidProof
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.solved[challenge]; ok {
+		return ErrInvalidProof
+	}
+	g.solved[challenge] = issued
+	return nil
+}
+
+// LeadingZeros returns the number of leading zero bits of a hash.
+func LeadingZeros(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			for b&0x80 == 0 {
+				n++
+				b <<= 1
+			}
+			return n
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package invite
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseEmail(t *testing.T) {
+	valid := map[string]string{
+		"alice@example.org":           "alice@example.org",
+		" Alice.Liddell@Example.ORG ": "Alice.Liddell@example.org",
+		"bob+liri@mail.example.com":   "bob+liri@mail.example.com",
+	}
+	for email, expected := range valid {
+		if parsed, err := ParseEmail(email); err != nil || parsed != expected {
+			t.Errorf("%q: expected %q, got %q, %v", email, expected, parsed, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"alice",
+		"alice@localhost",
+		"alice@example..org",
+		"Alice <alice@example.org>",
+		"alice@example.org, bob@example.org",
+		"@example.org",
+	}
+	for _, email := range invalid {
+		if _, err := ParseEmail(email); err != ErrInvalidEmail {
+			t.Errorf("%q: expected an invalid address, got %v", email, err)
+		}
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	blocked := []string{"spam.example", "@Junk.example"}
+	for _, email := range []string{"a@mailinator.com", "a@eu.yopmail.com", "a@spam.example", "a@junk.example"} {
+		if CheckDomain(email, blocked) != ErrDisposableEmail {
+			t.Errorf("%s: expected the domain to be blocked", email)
+		}
+	}
+	for _, email := range []string{"a@example.org", "a@notspam.example"} {
+		if err := CheckDomain(email, blocked); err != nil {
+			t.Errorf("%s: unexpected error %v", email, err)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	g := NewGuard()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := g.Allow("192.0.2.1", 3, time.Hour, now.Add(time.Duration(i)*time.Minute)); !ok {
+			t.Fatalf("attempt %d: expected to be allowed", i)
+		}
+	}
+	ok, wait := g.Allow("192.0.2.1", 3, time.Hour, now.Add(10*time.Minute))
+	if ok || wait != 50*time.Minute {
+		t.Errorf("expected to wait 50m, got %v, %s", ok, wait)
+	}
+	if ok, _ := g.Allow("192.0.2.2", 3, time.Hour, now); !ok {
+		t.Errorf("expected other clients to be allowed")
+	}
+	if ok, _ := g.Allow("192.0.2.1", 3, time.Hour, now.Add(61*time.Minute)); !ok {
+		t.Errorf("expected to be allowed again after the window")
+	}
+}
+
+// solve finds a nonce for the challenge the way clients do.
+func solve(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if LeadingZeros(sha256.Sum256([]byte(challenge+":"+nonce))) >= difficulty {
+			return nonce
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	g := NewGuard()
+	now := time.Now()
+	challenge := g.Challenge(now)
+	nonce := solve(challenge, 12)
+
+	if err := g.Verify(challenge, nonce, 20, now); err != ErrInvalidProof {
+		t.Errorf("expected too easy a proof to be rejected, got %v", err)
+	}
+	if err := g.Verify(challenge+"x", nonce, 12, now); err != ErrInvalidProof {
+		t.Errorf("expected a forged challenge to be rejected, got %v", err)
+	}
+	if err := g.Verify(challenge, nonce, 12, now.Add(ChallengeTTL)); err != ErrInvalidProof {
+		t.Errorf("expected an expired challenge to be rejected, got %v", err)
+	}
+	if err := g.Verify(challenge, nonce, 12, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected the proof to be accepted, got %v", err)
+	}
+	if err := g.Verify(challenge, nonce, 12, now.Add(time.Minute)); err != ErrInvalidProof {
+		t.Errorf("expected a solved challenge to be rejected, got %v", err)
+	}
+	if err := NewGuard().Verify(challenge, nonce, 12, now); err != ErrInvalidProof {
+		t.Errorf("expected challenges of another guard to be rejected, got %v", err)
+	}
+}
@@ -24,71 +24,163 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	api "github.com/lirios/website/api"
 	server "github.com/lirios/website/server"
-	"gopkg.in/gcfg.v1"
 )
 
-// Context of the application.
-type ctx struct {
-	settings *server.Settings
-}
-
-func (c ctx) Settings() *server.Settings {
-	return c.settings
-}
-
 // Application handler.
 type appHandler struct {
 	*ctx
-	handler func(server.Context, http.ResponseWriter, *http.Request) (int, []byte)
+	handler api.HandlerFunc
 }
 
 func (t appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	code, data := t.handler(t.ctx, w, r)
-	if code != http.StatusOK {
-		http.Error(w, string(data), code)
-		return
-	}
-	w.Write(data)
+	code, body, err := t.handler(t.ctx, w, r)
+	server.Respond(w, r, code, body, err)
 }
 
-// Routes.
+// Routes. Streams last as long as clients listen, regardless of
+// the write timeout.
 var routes = []struct {
 	method  string
 	route   string
-	handler func(server.Context, http.ResponseWriter, *http.Request) (int, []byte)
+	handler api.HandlerFunc
+	stream  bool
 }{
-	{"GET", "/api/team", api.TeamHandler},
+	{"GET", "/api/team", api.TeamHandler, false},
+	{"GET", "/api/team/audit", api.TeamAuditHandler, false},
+	{"GET", "/api/team/events", api.TeamEventsHandler, true},
+	{"GET", "/api/team/stats", api.TeamStatsHandler, false},
+	{"GET", "/badge/chat.svg", api.ChatBadgeHandler, false},
+	{"GET", "/avatars/{id}", api.AvatarHandler, false},
+	{"GET", "/api/invite", api.InviteInfoHandler, false},
+	{"POST", "/api/invite", api.InviteHandler, false},
+	{"GET", "/api/contributors", api.ContributorsHandler, false},
+	{"GET", "/api/releases", api.ReleasesHandler, false},
+	{"GET", "/api/releases/latest", api.LatestReleasesHandler, false},
+	{"GET", "/api/releases/latest/{channel}", api.LatestReleaseHandler, false},
+	{"GET", "/api/releases/{version}", api.ReleaseHandler, false},
+	{"GET", "/api/mirrors/status", api.MirrorsStatusHandler, false},
+	{"GET", "/download/{release}/{file}", api.DownloadHandler, false},
+	{"POST", "/hooks/slack/events", api.SlackEventsHandler, false},
+}
+
+// timeoutBody is the response to the requests taking longer than
+// the write timeout.
+const timeoutBody = `{"ok":false,"error":"timeout","message":"The request took too long"}`
+
+// newServer creates the server of the routes. The write timeout is
+// enforced by the handlers of the routes rather than by the server,
+// which would end the streams.
+func newServer(c *ctx, settings *server.Settings) *http.Server {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		server.Respond(w, req, 0, nil, server.NotFound("not found"))
+	})
+
+	writeTimeout := settings.Server.WriteTimeout.OrDefault(server.DefaultWriteTimeout)
+	for _, detail := range routes {
+		var handler http.Handler = appHandler{c, detail.handler}
+		if !detail.stream {
+			handler = http.TimeoutHandler(handler, writeTimeout, timeoutBody)
+		}
+		r.Handle(detail.route, handler).Methods(detail.method)
+	}
+
+	port := settings.Server.Port
+	if port == "" {
+		port = server.DefaultPort
+	}
+	return &http.Server{
+		Addr:        port,
+		Handler:     r,
+		ReadTimeout: settings.Server.ReadTimeout.OrDefault(server.DefaultReadTimeout),
+		IdleTimeout: settings.Server.IdleTimeout.OrDefault(server.DefaultIdleTimeout),
+	}
+}
+
+// serve serves requests until a signal is received, then waits for
+// the requests in flight to complete within the shutdown timeout.
+// The onShutdown functions are called first, to end long running
+// requests such as event streams.
+func serve(srv *http.Server, shutdownTimeout time.Duration, signals <-chan os.Signal, onShutdown ...func()) error {
+	// Listen first, so that failing to bind the port is reported right away
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+	log.Printf("Listening on %s", listener.Addr())
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+	for _, f := range onShutdown {
+		f()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:], os.Stderr))
+	}
+
 	// Load settings
-	var settingsFileName = "./config.ini"
-	if len(os.Args) > 1 {
-		settingsFileName = os.Args[1:][0]
+	printConfig := flag.Bool("print-config", false, "print the effective settings with secrets redacted and exit")
+	loader := parseFlags(flag.CommandLine, os.Args[1:])
+	if *printConfig {
+		settings, problems := loader.check()
+		settings.Write(os.Stdout)
+		if len(problems) > 0 {
+			log.Fatalf("Invalid settings:\n%v", problems)
+		}
+		return
 	}
-	var settings server.Settings
-	err := gcfg.ReadFileInto(&settings, settingsFileName)
+	settings, err := loader.load()
 	if err != nil {
-		panic(err)
+		log.Fatalf("Failed to read settings: %v", err)
 	}
 
 	// Create context
-	appContext := &ctx{&settings}
-
-	// Create router
-	r := mux.NewRouter()
-
-	// Add routes
-	for _, detail := range routes {
-		r.Handle(detail.route, appHandler{appContext, detail.handler}).Methods(detail.method)
+	appContext, err := newContext(settings)
+	if err != nil {
+		log.Fatalf("Failed to apply settings: %v", err)
 	}
+	stop := make(chan struct{})
+	go appContext.mirrors.Run(stop)
+	go appContext.watchSettings(loader, stop)
+	go api.WatchTeam(appContext, stop)
 
 	// Serve
-	http.ListenAndServe(settings.Server.Port, r)
+	srv := newServer(appContext, settings)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	err = serve(srv, settings.Server.ShutdownTimeout.OrDefault(server.DefaultShutdownTimeout),
+		signals, appContext.teamEvents.Close)
+	close(stop)
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	log.Printf("Server stopped")
 }
@@ -24,7 +24,21 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	server "github.com/lirios/website/server"
 )
 
 func TestMain(t *testing.T) {
@@ -34,3 +48,331 @@ func TestMain(t *testing.T) {
 func TestApiTeam(t *testing.T) {
 	return
 }
+
+func TestServeBindFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	srv := &http.Server{Addr: listener.Addr().String()}
+	if err := serve(srv, time.Second, nil); err == nil {
+		t.Fatal("expected an error binding a port in use")
+	}
+}
+
+func TestServeShutdownOnSignal(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+	signals := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- serve(srv, 5*time.Second, signals)
+	}()
+	signals <- syscall.SIGTERM
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		w.Write([]byte(`{"ok": true, "members": []}`))
+	}))
+	defer slack.Close()
+
+	settings := server.Defaults()
+	settings.Slack.URL = slack.URL
+	settings.Server.WriteTimeout.Duration = 100 * time.Millisecond
+	c, err := newContext(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newServer(c, settings)
+	ts.Start()
+	defer ts.Close()
+
+	// Other requests still time out
+	resp, err := http.Get(ts.URL + "/api/team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a slow request to time out, got status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/api/team/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * settings.Server.WriteTimeout.Duration)
+	c.TeamEvents().Publish("presence", map[string]string{"name": "alice"})
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended after the write timeout: %v", err)
+		}
+		if line == "event: presence\n" {
+			break
+		}
+	}
+}
+
+func TestReloadSettings(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("[slack]\ntoken = old\n")
+	loader := &settingsLoader{fileName: f.Name()}
+	settings, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newContext(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid settings are not applied
+	write("[slack]\ntoken = new\n[mirror \"broken\"]\nweight = 1\n")
+	if err := c.reload(loader); err == nil {
+		t.Error("expected an error reloading invalid settings")
+	}
+	if token := c.Settings().Slack.Token; token != "old" {
+		t.Errorf("expected the old token to be kept, got %q", token)
+	}
+
+	write("[slack]\ntoken = new\n[mirror \"fixed\"]\nurl = https://mirror.example.org\n")
+	if err := c.reload(loader); err != nil {
+		t.Fatal(err)
+	}
+	if token := c.Settings().Slack.Token; token != "new" {
+		t.Errorf("expected the new token, got %q", token)
+	}
+	if n := len(c.Mirrors().Mirrors()); n != 1 {
+		t.Errorf("expected 1 mirror, got %d", n)
+	}
+}
+
+func TestReloadKeepsUnchangedSources(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.Write([]byte(`{"ok": true, "members": []}`))
+	}))
+	defer slack.Close()
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	geoIP := filepath.Join(dir, "geoip.csv")
+	if err := ioutil.WriteFile(geoIP, []byte("1.0.0.0,1.0.0.255,AU\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loader := &settingsLoader{fileName: filepath.Join(dir, "config.ini")}
+	write := func(token, organization string) {
+		content := "[slack]\nurl = " + slack.URL + "\ntoken = " + token +
+			"\n[github]\norganization = " + organization +
+			"\n[download]\ngeoip = " + geoIP + "\n"
+		if err := ioutil.WriteFile(loader.fileName, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old", "lirios")
+	settings, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newContext(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stale teams are fetched again in the background
+	fetched := func(wait time.Duration) bool {
+		if _, err := c.TeamCache().Get(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-calls:
+			return true
+		case <-time.After(wait):
+			return false
+		}
+	}
+	db := c.GeoIP()
+	if db == nil {
+		t.Fatal("expected the GeoIP database to be loaded")
+	}
+	if !fetched(5 * time.Second) {
+		t.Fatal("expected the team to be fetched")
+	}
+
+	// Unrelated changes keep the team and the database
+	write("old", "other")
+	if err := c.reload(loader); err != nil {
+		t.Fatal(err)
+	}
+	if fetched(100 * time.Millisecond) {
+		t.Error("expected the team to stay cached")
+	}
+	if c.GeoIP() != db {
+		t.Error("expected the GeoIP database to be kept")
+	}
+
+	write("new", "other")
+	if err := c.reload(loader); err != nil {
+		t.Fatal(err)
+	}
+	if !fetched(5 * time.Second) {
+		t.Error("expected the team to be fetched again with the new token")
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	f, err := ioutil.TempFile("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[server]\nport = :9000\n[slack]\ntoken = file\n[github]\norganization = file\n")
+	f.Close()
+
+	os.Setenv("SLACK_TOKEN", "env")
+	os.Setenv("GITHUB_ORGANIZATION", "env")
+	defer os.Unsetenv("SLACK_TOKEN")
+	defer os.Unsetenv("GITHUB_ORGANIZATION")
+
+	flags := flag.NewFlagSet("website", flag.ContinueOnError)
+	loader := parseFlags(flags, []string{"-github.organization", "flag", "-server.trustproxy", f.Name()})
+	settings, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Server.Port != ":9000" {
+		t.Errorf("expected the port from the file, got %q", settings.Server.Port)
+	}
+	if settings.Slack.Token != "env" {
+		t.Errorf("expected the token from the environment, got %q", settings.Slack.Token)
+	}
+	if settings.GitHub.Organization != "flag" {
+		t.Errorf("expected the organization from the command line, got %q", settings.GitHub.Organization)
+	}
+	if !settings.Server.TrustProxy {
+		t.Error("expected trustproxy to be set from the command line")
+	}
+	if settings.Server.ReadTimeout.Duration != server.DefaultReadTimeout {
+		t.Errorf("expected the default read timeout, got %v", settings.Server.ReadTimeout)
+	}
+}
+
+func TestSecretPrecedence(t *testing.T) {
+	secret, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secret.Name())
+	secret.WriteString("flag\n")
+	secret.Close()
+
+	f, err := ioutil.TempFile("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[slack]\ntoken-file = /nonexistent/slack_token\n[matrix]\ntoken = file\n")
+	f.Close()
+
+	os.Setenv("SLACK_TOKEN", "env")
+	defer os.Unsetenv("SLACK_TOKEN")
+
+	flags := flag.NewFlagSet("website", flag.ContinueOnError)
+	loader := parseFlags(flags, []string{"-matrix.token-file", secret.Name(), f.Name()})
+	settings, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Slack.Token != "env" {
+		t.Errorf("expected the token from the environment, got %q", settings.Slack.Token)
+	}
+	if settings.Matrix.Token != "flag" {
+		t.Errorf("expected the token from the file given on the command line, got %q", settings.Matrix.Token)
+	}
+
+	// Both given in the same place are still a mistake
+	os.Setenv("SLACK_TOKEN_FILE", secret.Name())
+	defer os.Unsetenv("SLACK_TOKEN_FILE")
+	if _, err := loader.load(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("expected token and token-file to conflict, got %v", err)
+	}
+}
+
+func TestMissingSettingsFile(t *testing.T) {
+	os.Setenv("SLACK_TOKEN", "env")
+	defer os.Unsetenv("SLACK_TOKEN")
+
+	loader := &settingsLoader{fileName: "/nonexistent/config.ini", optional: true}
+	if _, err := loader.load(); err != nil {
+		t.Errorf("expected a missing default file to be ignored, got %v", err)
+	}
+	loader.optional = false
+	if _, err := loader.load(); err == nil {
+		t.Error("expected an error for a missing settings file")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[server]\nport = 8080\nreadtimeout = soon\n[slack]\nurl = https://slack.com/api\n")
+	f.Close()
+
+	var out bytes.Buffer
+	if status := checkConfig([]string{f.Name()}, &out); status != 1 {
+		t.Errorf("expected exit status 1, got %d", status)
+	}
+	for _, expected := range []string{
+		f.Name() + ":2:1: [server] port: invalid port",
+		f.Name() + ":3:1: [server] readtimeout: time: invalid duration",
+		f.Name() + ":4:1: [slack] token: missing token",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, out.String())
+		}
+	}
+
+	os.Setenv("SLACK_TOKEN", "env")
+	defer os.Unsetenv("SLACK_TOKEN")
+	ioutil.WriteFile(f.Name(), []byte("[server]\nport = 8080\n"), 0600)
+	out.Reset()
+	if status := checkConfig([]string{"-server.port", ":8080", f.Name()}, &out); status != 0 {
+		t.Errorf("expected exit status 0, got %d:\n%s", status, out.String())
+	}
+}
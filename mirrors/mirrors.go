@@ -0,0 +1,327 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package mirrors picks the best mirror to download files from.
+package mirrors
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	geoip "github.com/lirios/website/geoip"
+)
+
+// checkTimeout is how long to wait for a mirror to answer a health check.
+const checkTimeout = 10 * time.Second
+
+// Mirror is a server hosting a copy of the downloads.
+type Mirror struct {
+	Name      string
+	URL       string
+	AltURLs   []string
+	Weight    int
+	Country   string
+	Continent string
+}
+
+// weight returns the weight of a mirror, which defaults to 1.
+func (m *Mirror) weight() int {
+	if m.Weight <= 0 {
+		return 1
+	}
+	return m.Weight
+}
+
+// continent returns the continent of a mirror, guessing it from
+// the country when not configured.
+func (m *Mirror) continent() string {
+	if m.Continent != "" {
+		return strings.ToUpper(m.Continent)
+	}
+	return geoip.Continent(m.Country)
+}
+
+// FileURL returns the URL of a file on the mirror.
+func (m *Mirror) FileURL(path string) string {
+	return strings.TrimSuffix(m.URL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Protocols returns the URL schemes the mirror can be accessed with.
+func (m *Mirror) Protocols() []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, rawurl := range append([]string{m.URL}, m.AltURLs...) {
+		u, err := url.Parse(rawurl)
+		if err != nil || u.Scheme == "" || seen[u.Scheme] {
+			continue
+		}
+		seen[u.Scheme] = true
+		result = append(result, u.Scheme)
+	}
+	return result
+}
+
+// DefaultInterval is how often mirrors are checked unless configured otherwise.
+const DefaultInterval = 5 * time.Minute
+
+// Options configures the checks performed on the mirrors.
+type Options struct {
+	// Interval is how often mirrors are checked.
+	Interval time.Duration
+
+	// Trace is the path of a file, relative to the mirror URL,
+	// holding the time of the last synchronization.
+	Trace string
+
+	// MaxLag is how old the last synchronization can be before
+	// the mirror is considered stale.
+	MaxLag time.Duration
+}
+
+// Pool is a list of mirrors along with their status.
+type Pool struct {
+	client  *http.Client
+	options Options
+
+	mu      sync.Mutex
+	mirrors []*Mirror
+	status  map[string]*Status
+	rand    *rand.Rand
+	updated chan struct{}
+}
+
+// NewPool returns a pool of mirrors, all considered healthy until checked.
+func NewPool(mirrors []*Mirror, options Options) *Pool {
+	return &Pool{
+		client:  &http.Client{Timeout: checkTimeout},
+		options: options.withDefaults(),
+		mirrors: mirrors,
+		status:  make(map[string]*Status),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// withDefaults returns the options with defaults for missing values.
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.MaxLag <= 0 {
+		o.MaxLag = DefaultMaxLag
+	}
+	return o
+}
+
+// Update replaces the mirrors and the options of the pool, keeping
+// the status of the mirrors whose URL didn't change. Mirrors are
+// checked again right away when the pool is running.
+func (p *Pool) Update(mirrors []*Mirror, options Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := make(map[string]*Mirror)
+	for _, m := range p.mirrors {
+		previous[m.Name] = m
+	}
+	status := make(map[string]*Status)
+	for _, m := range mirrors {
+		if old, ok := previous[m.Name]; ok && old.URL == m.URL && p.status[m.Name] != nil {
+			status[m.Name] = p.status[m.Name]
+		}
+	}
+	p.mirrors, p.status, p.options = mirrors, status, options.withDefaults()
+
+	select {
+	case p.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Mirrors returns the mirrors in the pool.
+func (p *Pool) Mirrors() []*Mirror {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mirrors
+}
+
+// Healthy returns whether a mirror passed the last check,
+// being reachable and up to date.
+func (p *Pool) Healthy(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy(name)
+}
+
+// healthy returns whether a mirror passed the last check.
+// Must be called with the lock held.
+func (p *Pool) healthy(name string) bool {
+	status, ok := p.status[name]
+	return !ok || (status.Reachable && !status.Stale)
+}
+
+// Pick chooses a mirror for a client in the given country, preferring
+// mirrors in the same country, then in the same continent. Mirrors are
+// chosen randomly according to their weight.
+// It returns nil when no mirror is healthy.
+func (p *Pool) Pick(country string) *Mirror {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := make([]*Mirror, 0, len(p.mirrors))
+	for _, m := range p.mirrors {
+		if p.healthy(m.Name) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	country = strings.ToUpper(country)
+	continent := geoip.Continent(country)
+	var local, regional []*Mirror
+	for _, m := range candidates {
+		if country != "" && strings.ToUpper(m.Country) == country {
+			local = append(local, m)
+		}
+		if continent != "" && m.continent() == continent {
+			regional = append(regional, m)
+		}
+	}
+	switch {
+	case len(local) > 0:
+		return p.weighted(local)
+	case len(regional) > 0:
+		return p.weighted(regional)
+	}
+	return p.weighted(candidates)
+}
+
+// weighted picks a random mirror according to the weights.
+// Must be called with the lock held.
+func (p *Pool) weighted(mirrors []*Mirror) *Mirror {
+	total := 0
+	for _, m := range mirrors {
+		total += m.weight()
+	}
+	if total == 0 {
+		return nil
+	}
+	n := p.rand.Intn(total)
+	for _, m := range mirrors {
+		n -= m.weight()
+		if n < 0 {
+			return m
+		}
+	}
+	return nil
+}
+
+// Check checks all mirrors concurrently, excluding those not answering
+// or lagging behind from the pool until they are back.
+func (p *Pool) Check() {
+	p.mu.Lock()
+	mirrors, options := p.mirrors, p.options
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, m := range mirrors {
+		wg.Add(1)
+		go func(m *Mirror) {
+			defer wg.Done()
+			status := p.check(m, options)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if !p.contains(m) {
+				// Removed while being checked
+				return
+			}
+			wasHealthy := p.healthy(m.Name)
+			p.status[m.Name] = status
+			if wasHealthy && !p.healthy(m.Name) {
+				log.Printf("Mirror %s is unhealthy: %s", m.Name, status.problem())
+			} else if !wasHealthy && p.healthy(m.Name) {
+				log.Printf("Mirror %s is back up", m.Name)
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+// contains returns whether a mirror is still in the pool.
+// Must be called with the lock held.
+func (p *Pool) contains(mirror *Mirror) bool {
+	for _, m := range p.mirrors {
+		if m == mirror {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the status of all mirrors, as of the last check.
+func (p *Pool) Status() []*Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]*Status, 0, len(p.mirrors))
+	for _, m := range p.mirrors {
+		status, ok := p.status[m.Name]
+		if !ok {
+			status = newStatus(m)
+		}
+		snapshot := *status
+		result = append(result, &snapshot)
+	}
+	return result
+}
+
+// Run checks the mirrors periodically until stop is closed.
+func (p *Pool) Run(stop <-chan struct{}) {
+	for {
+		// This check covers any update received so far
+		select {
+		case <-p.updated:
+		default:
+		}
+		p.Check()
+
+		p.mu.Lock()
+		timer := time.NewTimer(p.options.Interval)
+		p.mu.Unlock()
+		select {
+		case <-timer.C:
+		case <-p.updated:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
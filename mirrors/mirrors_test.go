@@ -0,0 +1,112 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package mirrors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPickAffinity(t *testing.T) {
+	pool := NewPool([]*Mirror{
+		{Name: "italy", URL: "https://it.example.org", Country: "IT"},
+		{Name: "germany", URL: "https://de.example.org", Country: "DE"},
+		{Name: "usa", URL: "https://us.example.org", Country: "US", Weight: 100},
+		{Name: "oceania", URL: "https://oc.example.org", Continent: "OC"},
+	}, Options{})
+	tests := []struct {
+		country string
+		names   []string
+	}{
+		{"IT", []string{"italy"}},
+		{"fr", []string{"italy", "germany"}},
+		{"AU", []string{"oceania"}},
+		{"CA", []string{"usa"}},
+		{"", []string{"italy", "germany", "usa", "oceania"}},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			m := pool.Pick(test.country)
+			found := false
+			for _, name := range test.names {
+				found = found || m.Name == name
+			}
+			if !found {
+				t.Errorf("unexpected mirror %s for country %q", m.Name, test.country)
+			}
+		}
+	}
+}
+
+func TestPickWeight(t *testing.T) {
+	pool := NewPool([]*Mirror{
+		{Name: "small", URL: "https://small.example.org", Weight: 1},
+		{Name: "large", URL: "https://large.example.org", Weight: 9},
+	}, Options{})
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[pool.Pick("").Name]++
+	}
+	if counts["large"] < 800 || counts["small"] < 50 {
+		t.Errorf("picks do not follow weights: %v", counts)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	pool := NewPool([]*Mirror{
+		{Name: "up", URL: up.URL, Country: "IT"},
+		{Name: "down", URL: down.URL, Country: "IT", Weight: 1000},
+	}, Options{})
+	pool.Check()
+	if !pool.Healthy("up") || pool.Healthy("down") {
+		t.Fatal("unexpected health check results")
+	}
+	for i := 0; i < 20; i++ {
+		if m := pool.Pick("IT"); m.Name != "up" {
+			t.Fatalf("picked unhealthy mirror %s", m.Name)
+		}
+	}
+
+	up.Close()
+	pool.Check()
+	if m := pool.Pick("IT"); m != nil {
+		t.Errorf("expected no mirror when all are down, got %s", m.Name)
+	}
+}
+
+func TestPickEmpty(t *testing.T) {
+	if m := NewPool(nil, Options{}).Pick("IT"); m != nil {
+		t.Errorf("expected no mirror, got %s", m.Name)
+	}
+}
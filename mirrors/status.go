@@ -0,0 +1,193 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package mirrors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxLag is how old the last synchronization of a mirror can be
+// unless configured otherwise.
+const DefaultMaxLag = 24 * time.Hour
+
+// maxTraceSize limits how much of a trace file is read.
+const maxTraceSize = 4096
+
+// traceLayouts are the formats accepted for the time in a trace file,
+// besides seconds since the Unix epoch.
+var traceLayouts = []string{time.RFC3339, time.UnixDate, time.RFC1123, time.RFC1123Z}
+
+// Status is the outcome of the last check of a mirror.
+type Status struct {
+	Name      string     `json:"name"`
+	URL       string     `json:"url"`
+	Country   string     `json:"country,omitempty"`
+	Protocols []string   `json:"protocols"`
+	Reachable bool       `json:"reachable"`
+	LastSync  *time.Time `json:"last_sync,omitempty"`
+	Lag       *int64     `json:"lag"`
+	Stale     bool       `json:"stale"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// newStatus returns the status of a mirror that wasn't checked yet.
+func newStatus(m *Mirror) *Status {
+	return &Status{
+		Name:      m.Name,
+		URL:       m.URL,
+		Country:   m.Country,
+		Protocols: m.Protocols(),
+		Reachable: true,
+	}
+}
+
+// problem describes why a mirror is unhealthy.
+func (s *Status) problem() string {
+	if s.Error != "" {
+		return s.Error
+	}
+	if s.Stale && s.Lag != nil {
+		return fmt.Sprintf("last synchronized %s ago", time.Duration(*s.Lag)*time.Second)
+	}
+	return "unknown"
+}
+
+// check checks whether a mirror is reachable and, when a trace file
+// is configured, how far behind it is.
+func (p *Pool) check(m *Mirror, options Options) *Status {
+	status := newStatus(m)
+	now := time.Now().UTC()
+	status.LastCheck = &now
+
+	if options.Trace == "" {
+		status.Reachable, status.Error = errorStatus(p.ping(m))
+		return status
+	}
+
+	synced, err := p.trace(m, options.Trace)
+	status.Reachable, status.Error = errorStatus(err)
+	if err != nil {
+		if _, ok := err.(*traceError); ok {
+			// The server answered but the trace file is unusable
+			status.Reachable = true
+			status.Stale = true
+		}
+		return status
+	}
+	synced = synced.UTC()
+	status.LastSync = &synced
+	// Lag is unknown, rather than zero, until the trace file is read
+	lag := int64(now.Sub(synced) / time.Second)
+	if lag < 0 {
+		lag = 0
+	}
+	status.Lag = &lag
+	status.Stale = now.Sub(synced) > options.MaxLag
+	return status
+}
+
+// errorStatus converts the result of a check to the reachable flag
+// and the error message of the status.
+func errorStatus(err error) (bool, string) {
+	if err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+// ping returns an error if the mirror is not reachable.
+func (p *Pool) ping(m *Mirror) error {
+	resp, err := p.client.Head(m.FileURL("/"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// Directory listings may be forbidden, but the server is up
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusNotFound {
+		return errors.New("unexpected response " + resp.Status)
+	}
+	return nil
+}
+
+// traceError is returned when a mirror serves an invalid trace file.
+type traceError struct {
+	message string
+}
+
+func (e *traceError) Error() string {
+	return e.message
+}
+
+// trace returns the time of the last synchronization of a mirror,
+// reading it from the trace file.
+func (p *Pool) trace(m *Mirror, trace string) (time.Time, error) {
+	resp, err := p.client.Get(m.FileURL(trace))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return time.Time{}, errors.New("unexpected response " + resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, &traceError{"trace file: unexpected response " + resp.Status}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTraceSize))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t, ok := parseTrace(string(data)); ok {
+		return t, nil
+	}
+
+	// Fall back to the modification time of the file
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return t, nil
+	}
+	return time.Time{}, &traceError{"trace file: unknown time format"}
+}
+
+// parseTrace reads the time from the first line of a trace file.
+func parseTrace(data string) (time.Time, bool) {
+	line := strings.TrimSpace(strings.SplitN(data, "\n", 2)[0])
+	if seconds, err := strconv.ParseInt(line, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	for _, layout := range traceLayouts {
+		if t, err := time.Parse(layout, line); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,122 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package mirrors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMirror serves a trace file with the given content.
+func fakeMirror(trace string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lastsync" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(trace))
+	}))
+}
+
+func TestFreshness(t *testing.T) {
+	fresh := fakeMirror(strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + "\n")
+	defer fresh.Close()
+	dated := fakeMirror(time.Now().Add(-2 * time.Hour).UTC().Format(time.UnixDate))
+	defer dated.Close()
+	stale := fakeMirror(time.Now().Add(-72 * time.Hour).Format(time.RFC3339))
+	defer stale.Close()
+	garbage := fakeMirror("not a time")
+	defer garbage.Close()
+	gone := fakeMirror("")
+	gone.Close()
+
+	pool := NewPool([]*Mirror{
+		{Name: "dated", URL: dated.URL},
+		{Name: "fresh", URL: fresh.URL, AltURLs: []string{"rsync://fresh.example.org/liri", "http://fresh.example.org/liri"}},
+		{Name: "garbage", URL: garbage.URL},
+		{Name: "gone", URL: gone.URL},
+		{Name: "stale", URL: stale.URL},
+	}, Options{Trace: "/lastsync", MaxLag: 6 * time.Hour})
+	pool.Check()
+
+	status := make(map[string]*Status)
+	for _, s := range pool.Status() {
+		status[s.Name] = s
+	}
+
+	s := status["fresh"]
+	if !s.Reachable || s.Stale || s.LastSync == nil || s.Lag == nil || *s.Lag < 3500 || *s.Lag > 3700 {
+		t.Errorf("unexpected status for fresh mirror %+v", s)
+	}
+	if len(s.Protocols) != 2 || s.Protocols[0] != "http" || s.Protocols[1] != "rsync" {
+		t.Errorf("unexpected protocols %v", s.Protocols)
+	}
+	if s := status["dated"]; !s.Reachable || s.Stale {
+		t.Errorf("unexpected status for dated mirror %+v", s)
+	}
+	if s := status["stale"]; !s.Reachable || !s.Stale {
+		t.Errorf("unexpected status for stale mirror %+v", s)
+	}
+	if s := status["garbage"]; !s.Reachable || !s.Stale || s.Error == "" || s.Lag != nil {
+		t.Errorf("unexpected status for mirror with invalid trace %+v", s)
+	}
+	if s := status["gone"]; s.Reachable || s.Error == "" {
+		t.Errorf("unexpected status for unreachable mirror %+v", s)
+	}
+
+	// Only up to date mirrors are picked
+	for i := 0; i < 20; i++ {
+		if m := pool.Pick(""); m.Name != "fresh" && m.Name != "dated" {
+			t.Fatalf("picked unhealthy mirror %s", m.Name)
+		}
+	}
+}
+
+func TestStatusBeforeCheck(t *testing.T) {
+	pool := NewPool([]*Mirror{{Name: "new", URL: "https://example.org"}}, Options{})
+	status := pool.Status()
+	if len(status) != 1 || !status[0].Reachable || status[0].LastCheck != nil {
+		t.Errorf("unexpected status %+v", status[0])
+	}
+	if data, _ := json.Marshal(status[0]); !strings.Contains(string(data), `"lag":null`) {
+		t.Errorf("expected an unknown lag, got %s", data)
+	}
+}
+
+func TestStatusSynced(t *testing.T) {
+	synced := fakeMirror(strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	defer synced.Close()
+	pool := NewPool([]*Mirror{{Name: "synced", URL: synced.URL}}, Options{Trace: "/lastsync"})
+	pool.Check()
+
+	// A mirror that just synchronized is not mistaken for an unknown lag
+	if data, _ := json.Marshal(pool.Status()[0]); !strings.Contains(string(data), `"lag":0`) {
+		t.Errorf("expected no lag, got %s", data)
+	}
+}
@@ -0,0 +1,318 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package releases
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of files published next to the images.
+const (
+	sha256Suffix    = ".sha256sum"
+	sha512Suffix    = ".sha512sum"
+	sha256Sums      = "SHA256SUMS"
+	sha512Sums      = "SHA512SUMS"
+	notesPrefix     = "release-notes"
+	signatureSuffix = ".sig"
+	armorSuffix     = ".asc"
+)
+
+// errFileChanged is returned when a file changes while it is read.
+var errFileChanged = errors.New("file changed while computing its checksums")
+
+// architectures are recognized in file names such as "liri-0.9.0-x86_64.iso".
+var architectures = []string{"x86_64", "aarch64", "armhfp", "armv7hl", "i686", "ppc64le"}
+
+// LoadDirectory builds the catalog scanning a directory laid out as
+// channel/version/files, such as "stable/0.9.0/liri-0.9.0-x86_64.iso".
+//
+// Checksums are read from sha256sum and sha512sum files next to each
+// image, or from SHA256SUMS and SHA512SUMS. When missing they are computed
+// in the background, images being large, and show up in the catalogs
+// loaded once they are ready, as long as the file doesn't change.
+// Signatures are detected from sig or asc files, and a file whose name
+// starts with "release-notes" is linked as release notes.
+func LoadDirectory(dir, baseURL string) (*Catalog, error) {
+	channels, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{}
+	computing := make(map[string]bool)
+	for _, channel := range channels {
+		if !channel.IsDir() || strings.HasPrefix(channel.Name(), ".") {
+			continue
+		}
+		versions, err := ioutil.ReadDir(filepath.Join(dir, channel.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			if !version.IsDir() || strings.HasPrefix(version.Name(), ".") {
+				continue
+			}
+			r := &Release{Version: version.Name(), Channel: channel.Name()}
+			if err := loadRelease(filepath.Join(dir, channel.Name(), version.Name()), baseURL, r, computing); err != nil {
+				return nil, err
+			}
+			if len(r.Files) > 0 {
+				catalog.Releases = append(catalog.Releases, r)
+			}
+		}
+	}
+	catalog.sort()
+
+	// Forget the checksums of the files that were removed
+	computed.prune(dir, computing)
+	return catalog, nil
+}
+
+// loadRelease fills a release with the files of its directory,
+// adding to computing those whose checksums are computed.
+func loadRelease(dir, baseURL string, r *Release, computing map[string]bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	sums256, err := readSums(filepath.Join(dir, sha256Sums))
+	if err != nil {
+		return err
+	}
+	sums512, err := readSums(filepath.Join(dir, sha512Sums))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || isSidecar(name) {
+			continue
+		}
+		if entry.ModTime().After(r.Date) {
+			r.Date = entry.ModTime().UTC()
+		}
+		if strings.HasPrefix(strings.ToLower(name), notesPrefix) {
+			r.NotesURL = fileURL(baseURL, r, name)
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		f := File{Name: name, Arch: architecture(name), Size: entry.Size(), URL: fileURL(baseURL, r, name)}
+		if f.SHA256, err = checksum(path+sha256Suffix, sums256[name]); err != nil {
+			return err
+		}
+		if f.SHA512, err = checksum(path+sha512Suffix, sums512[name]); err != nil {
+			return err
+		}
+		if f.SHA256 == "" || f.SHA512 == "" {
+			computing[path] = true
+			sum256, sum512 := computed.checksums(path, entry)
+			if f.SHA256 == "" {
+				f.SHA256 = sum256
+			}
+			if f.SHA512 == "" {
+				f.SHA512 = sum512
+			}
+		}
+		if names[name+signatureSuffix] {
+			f.SignatureURL = fileURL(baseURL, r, name+signatureSuffix)
+		} else if names[name+armorSuffix] {
+			f.SignatureURL = fileURL(baseURL, r, name+armorSuffix)
+		}
+		r.Files = append(r.Files, f)
+	}
+	return nil
+}
+
+// isSidecar returns whether a file describes another one rather than
+// being a download on its own.
+func isSidecar(name string) bool {
+	for _, suffix := range []string{sha256Suffix, sha512Suffix, signatureSuffix, armorSuffix} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return name == sha256Sums || name == sha512Sums
+}
+
+// architecture guesses the architecture of an image from its file name.
+func architecture(name string) string {
+	for _, arch := range architectures {
+		if strings.Contains(name, arch) {
+			return arch
+		}
+	}
+	return ""
+}
+
+// readSums reads a file in the format written by sha256sum and sha512sum,
+// returning the checksums by file name. A missing file is not an error.
+func readSums(fileName string) (map[string]string, error) {
+	result := make(map[string]string)
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			// Binary mode is marked with an asterisk before the name
+			result[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+		}
+	}
+	return result, scanner.Err()
+}
+
+// checksum returns the checksum of a file read from its sidecar file,
+// or sum when there is none.
+func checksum(sidecar, sum string) (string, error) {
+	data, err := ioutil.ReadFile(sidecar)
+	if err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			return strings.ToLower(fields[0]), nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	return sum, nil
+}
+
+// fileChecksums are the checksums of a version of a file,
+// empty until they are computed.
+type fileChecksums struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+	sha512  string
+}
+
+// checksumCache computes checksums in the background, one file at
+// a time, and remembers them by path.
+type checksumCache struct {
+	mu      sync.Mutex
+	files   map[string]*fileChecksums
+	busy    chan struct{}
+	pending sync.WaitGroup
+}
+
+// computed holds the checksums computed so far.
+var computed = &checksumCache{
+	files: make(map[string]*fileChecksums),
+	busy:  make(chan struct{}, 1),
+}
+
+// checksums returns the SHA256 and SHA512 checksums of a file, empty
+// while they are computed. A file that changed is computed again.
+func (c *checksumCache) checksums(path string, info os.FileInfo) (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sums := c.files[path]
+	if sums == nil || sums.size != info.Size() || !sums.modTime.Equal(info.ModTime()) {
+		sums = &fileChecksums{size: info.Size(), modTime: info.ModTime()}
+		c.files[path] = sums
+		c.pending.Add(1)
+		go c.compute(path, sums)
+	}
+	return sums.sha256, sums.sha512
+}
+
+// compute reads a file once to fill its checksums. They are dropped
+// if the file changed meanwhile, or could not be read, to be computed
+// again the next time they are asked for.
+func (c *checksumCache) compute(path string, sums *fileChecksums) {
+	defer c.pending.Done()
+	c.busy <- struct{}{}
+	defer func() {
+		<-c.busy
+	}()
+
+	h256, h512 := sha256.New(), sha512.New()
+	err := hashFile(path, sums, io.MultiWriter(h256, h512))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files[path] != sums {
+		return
+	}
+	if err != nil {
+		delete(c.files, path)
+		return
+	}
+	sums.sha256 = hex.EncodeToString(h256.Sum(nil))
+	sums.sha512 = hex.EncodeToString(h512.Sum(nil))
+}
+
+// hashFile copies a file to w, failing if it is not the expected version.
+func hashFile(path string, sums *fileChecksums, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != sums.size || !info.ModTime().Equal(sums.modTime) {
+		return errFileChanged
+	}
+	n, err := io.Copy(w, f)
+	if err == nil && n != sums.size {
+		err = errFileChanged
+	}
+	return err
+}
+
+// prune forgets the files under dir that are not in keep.
+func (c *checksumCache) prune(dir string, keep map[string]bool) {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.files {
+		if strings.HasPrefix(path, prefix) && !keep[path] {
+			delete(c.files, path)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package releases
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// manifest is the content of a release manifest file.
+type manifest struct {
+	Releases []*Release `json:"releases"`
+}
+
+// LoadManifest reads the catalog from a JSON manifest file.
+// Files without an URL are expected at baseURL/channel/version/name.
+func LoadManifest(fileName, baseURL string) (*Catalog, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data manifest
+	if err := json.NewDecoder(f).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{}
+	for _, r := range data.Releases {
+		for i := range r.Files {
+			if r.Files[i].URL == "" {
+				r.Files[i].URL = fileURL(baseURL, r, r.Files[i].Name)
+			}
+		}
+		catalog.Releases = append(catalog.Releases, r)
+	}
+	catalog.sort()
+	return catalog, nil
+}
+
+// fileURL returns the URL of a release file under baseURL.
+func fileURL(baseURL string, r *Release, name string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + r.Channel + "/" + r.Version + "/" + name
+}
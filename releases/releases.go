@@ -0,0 +1,187 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package releases describes the published Liri OS images.
+package releases
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a downloadable file of a release, such as an ISO image.
+type File struct {
+	Name         string `json:"name"`
+	Arch         string `json:"arch,omitempty"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256,omitempty"`
+	SHA512       string `json:"sha512,omitempty"`
+	URL          string `json:"url"`
+	SignatureURL string `json:"signature_url,omitempty"`
+}
+
+// Release is a version of Liri OS published on a channel.
+type Release struct {
+	Version  string    `json:"version"`
+	Channel  string    `json:"channel"`
+	Date     time.Time `json:"date"`
+	NotesURL string    `json:"notes_url,omitempty"`
+	Files    []File    `json:"files"`
+}
+
+// HasFile returns whether a file belongs to the release,
+// including signatures and release notes.
+func (r *Release) HasFile(name string) bool {
+	if r.NotesURL != "" && path.Base(r.NotesURL) == name {
+		return true
+	}
+	for _, f := range r.Files {
+		if f.Name == name || (f.SignatureURL != "" && path.Base(f.SignatureURL) == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Catalog is the list of releases, newest first.
+type Catalog struct {
+	Releases []*Release
+}
+
+// Find returns the release with the given version, or nil.
+func (c *Catalog) Find(version string) *Release {
+	for _, r := range c.Releases {
+		if r.Version == version {
+			return r
+		}
+	}
+	return nil
+}
+
+// Latest returns the newest release published on a channel, or nil.
+func (c *Catalog) Latest(channel string) *Release {
+	for _, r := range c.Releases {
+		if r.Channel == channel {
+			return r
+		}
+	}
+	return nil
+}
+
+// Channels returns the channels with at least one release, sorted by name.
+func (c *Catalog) Channels() []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, r := range c.Releases {
+		if !seen[r.Channel] {
+			seen[r.Channel] = true
+			result = append(result, r.Channel)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Channel returns the releases published on a channel, newest first.
+func (c *Catalog) Channel(channel string) []*Release {
+	result := []*Release{}
+	for _, r := range c.Releases {
+		if r.Channel == channel {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// sort puts the newest releases first.
+func (c *Catalog) sort() {
+	sort.Sort(byAge(c.Releases))
+}
+
+// byAge sorts releases from the newest to the oldest.
+type byAge []*Release
+
+// Len returns the length of the slice.
+func (slice byAge) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice byAge) Less(i, j int) bool {
+	if !slice[i].Date.Equal(slice[j].Date) {
+		return slice[i].Date.After(slice[j].Date)
+	}
+	return compareVersions(slice[i].Version, slice[j].Version) > 0
+}
+
+// Swap swaps two slice items.
+func (slice byAge) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// compareVersions compares two version strings such as "0.9.0" and
+// "0.10.0-rc1", comparing numeric components as numbers and putting
+// pre-releases before the final version.
+// It returns a negative number when a < b, zero when a == b
+// and a positive number when a > b.
+func compareVersions(a, b string) int {
+	split := func(r rune) bool {
+		return r == '.' || r == '-' || r == '_' || r == '+'
+	}
+	pa, pb := strings.FieldsFunc(a, split), strings.FieldsFunc(b, split)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, erra := strconv.Atoi(pa[i])
+		nb, errb := strconv.Atoi(pb[i])
+		switch {
+		case erra == nil && errb == nil:
+			if na != nb {
+				return na - nb
+			}
+		case pa[i] != pb[i]:
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(pa) > len(pb):
+		return extraComponent(pa[len(pb)])
+	case len(pa) < len(pb):
+		return -extraComponent(pb[len(pa)])
+	}
+	return 0
+}
+
+// extraComponent tells how the version with an additional component
+// compares to the one without it: "1.0.1" is newer than "1.0" while
+// "1.0-rc1" is older.
+func extraComponent(component string) int {
+	if _, err := strconv.Atoi(component); err == nil {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,199 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package releases
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		sign int
+	}{
+		{"0.9.0", "0.9.0", 0},
+		{"0.10.0", "0.9.0", 1},
+		{"0.9.0", "0.9.0.1", -1},
+		{"0.9.0-rc1", "0.9.0", -1},
+		{"0.9.0-rc2", "0.9.0-rc1", 1},
+		{"2017.10.15", "2017.9.30", 1},
+	}
+	for _, test := range tests {
+		result := compareVersions(test.a, test.b)
+		if (result > 0 && test.sign <= 0) || (result < 0 && test.sign >= 0) || (result == 0 && test.sign != 0) {
+			t.Errorf("compareVersions(%q, %q) = %d", test.a, test.b, result)
+		}
+	}
+}
+
+// writeFile creates a file with its parent directories.
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "stable", "0.9.0", "liri-0.9.0-x86_64.iso"), "old image", old)
+	writeFile(t, filepath.Join(dir, "stable", "0.9.1", "liri-0.9.1-x86_64.iso"), "image", recent)
+	writeFile(t, filepath.Join(dir, "stable", "0.9.1", "liri-0.9.1-x86_64.iso.sha256sum"), "ABCDEF  liri-0.9.1-x86_64.iso\n", recent)
+	writeFile(t, filepath.Join(dir, "stable", "0.9.1", "liri-0.9.1-x86_64.iso.sig"), "signature", recent)
+	writeFile(t, filepath.Join(dir, "stable", "0.9.1", "release-notes.html"), "notes", recent)
+	writeFile(t, filepath.Join(dir, "unstable", "2017.10.15", "liri-2017.10.15-aarch64.iso"), "nightly", old)
+
+	catalog, err := LoadDirectory(dir, "https://dl.example.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Releases) != 3 {
+		t.Fatalf("expected 3 releases, got %d", len(catalog.Releases))
+	}
+
+	latest := catalog.Latest("stable")
+	if latest == nil || latest.Version != "0.9.1" {
+		t.Fatalf("expected latest stable release 0.9.1, got %+v", latest)
+	}
+	if latest.NotesURL != "https://dl.example.org/stable/0.9.1/release-notes.html" {
+		t.Errorf("unexpected release notes %q", latest.NotesURL)
+	}
+	if len(latest.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(latest.Files))
+	}
+	f := latest.Files[0]
+	if f.Arch != "x86_64" || f.Size != 5 || f.SHA256 != "abcdef" {
+		t.Errorf("unexpected file %+v", f)
+	}
+	if f.SignatureURL != "https://dl.example.org/stable/0.9.1/liri-0.9.1-x86_64.iso.sig" {
+		t.Errorf("unexpected signature %q", f.SignatureURL)
+	}
+
+	if r := catalog.Find("2017.10.15"); r == nil || r.Files[0].Arch != "aarch64" {
+		t.Errorf("unexpected unstable release %+v", r)
+	}
+}
+
+// loadChecksums loads a directory with a single image and returns its checksums.
+func loadChecksums(t *testing.T, dir string) (string, string) {
+	catalog, err := LoadDirectory(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := catalog.Releases[0].Files[0]
+	return f.SHA256, f.SHA512
+}
+
+func TestLoadDirectoryChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "stable", "0.9.1", "liri-0.9.1-x86_64.iso")
+	writeFile(t, image, "image", time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	// Checksums are computed in the background when not published
+	if sum256, sum512 := loadChecksums(t, dir); sum256 != "" || sum512 != "" {
+		t.Errorf("expected no checksums while they are computed, got %q and %q", sum256, sum512)
+	}
+	computed.pending.Wait()
+	sum256, sum512 := loadChecksums(t, dir)
+	if sum256 != "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d" {
+		t.Errorf("expected a computed SHA256 checksum, got %q", sum256)
+	}
+	if sum512 != "eb31d04da633dc9f49dfbd66cdb92fbb9b4f9c9be67914c0209b5dd31cc65a136e1cdce7d0db88112e3a759131b9d970cfaac7ee77ccd620c3dd49043f88958e" {
+		t.Errorf("expected a computed SHA512 checksum, got %q", sum512)
+	}
+
+	// Replaced images are computed again
+	writeFile(t, image, "new image", time.Date(2017, 9, 2, 0, 0, 0, 0, time.UTC))
+	if sum256, _ := loadChecksums(t, dir); sum256 != "" {
+		t.Errorf("expected the checksums of the replaced image to be dropped, got %q", sum256)
+	}
+	computed.pending.Wait()
+	if sum256, _ := loadChecksums(t, dir); sum256 == "" || sum256 == "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d" {
+		t.Errorf("expected the checksum of the new image, got %q", sum256)
+	}
+
+	// Removed images are forgotten
+	if err := os.Remove(image); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "stable", "0.9.1", "liri-0.9.1-aarch64.iso"), "other", time.Now())
+	loadChecksums(t, dir)
+	computed.pending.Wait()
+	computed.mu.Lock()
+	_, ok := computed.files[image]
+	computed.mu.Unlock()
+	if ok {
+		t.Error("expected the checksums of the removed image to be forgotten")
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "releases.json")
+	writeFile(t, manifest, `{"releases": [
+		{"version": "0.9.0", "channel": "stable", "date": "2017-06-01T00:00:00Z",
+		 "files": [{"name": "liri-0.9.0-x86_64.iso", "arch": "x86_64", "size": 42}]},
+		{"version": "0.10.0", "channel": "stable", "date": "2017-09-01T00:00:00Z",
+		 "files": [{"name": "liri.iso", "url": "https://mirror.example.org/liri.iso"}]}
+	]}`, time.Now())
+
+	catalog, err := LoadManifest(manifest, "https://dl.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := catalog.Latest("stable"); latest == nil || latest.Version != "0.10.0" {
+		t.Fatalf("expected latest stable release 0.10.0, got %+v", latest)
+	}
+	if url := catalog.Find("0.9.0").Files[0].URL; url != "https://dl.example.org/stable/0.9.0/liri-0.9.0-x86_64.iso" {
+		t.Errorf("unexpected file URL %q", url)
+	}
+	if url := catalog.Find("0.10.0").Files[0].URL; url != "https://mirror.example.org/liri.iso" {
+		t.Errorf("unexpected file URL %q", url)
+	}
+}
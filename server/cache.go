@@ -0,0 +1,228 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is used when no time to live is configured.
+const DefaultCacheTTL = 5 * time.Minute
+
+// maxRetryInterval limits how long a failed fetch waits before trying again,
+// unless the error asks to wait longer.
+const maxRetryInterval = 30 * time.Second
+
+// retryAfterer is an error telling how long to wait before trying again,
+// such as when an upstream service is rate limiting requests.
+type retryAfterer interface {
+	RetryAfter() time.Duration
+}
+
+// Cache keeps the result of an expensive fetch in memory.
+//
+// Values older than the time to live are still served while a single
+// background refresh replaces them. When the refresh fails the old value
+// keeps being served, so that an upstream outage doesn't break the site.
+type Cache struct {
+	fetch    func() (interface{}, error)
+	onUpdate func(old, new interface{})
+
+	// notifying serializes the update notifications.
+	notifying sync.Mutex
+
+	mu       sync.Mutex
+	ttl      time.Duration
+	value    interface{}
+	valid    bool
+	updated  time.Time
+	failed   time.Time
+	err      error
+	inflight chan struct{}
+}
+
+// NewCache returns a cache that fills itself calling fetch, whose
+// results are considered fresh for ttl.
+func NewCache(ttl time.Duration, fetch func() (interface{}, error)) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Cache{fetch: fetch, ttl: ttl}
+}
+
+// SetTTL changes the time to live of the cached value.
+func (c *Cache) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// OnUpdate sets a function called with the old and the new value
+// whenever the cached value changes, in order. It must be set before
+// the cache is used and must not use the cache.
+func (c *Cache) OnUpdate(f func(old, new interface{})) {
+	c.onUpdate = f
+}
+
+// Update replaces the cached value with the result of f, which must
+// not modify the value it's given. It returns false, without calling
+// f, when nothing is cached yet.
+func (c *Cache) Update(f func(v interface{}) interface{}) bool {
+	c.mu.Lock()
+	if !c.valid {
+		c.mu.Unlock()
+		return false
+	}
+	old := c.value
+	c.value = f(old)
+	c.notify(old, c.value)
+	return true
+}
+
+// notify unlocks the cache and calls the update function, making sure
+// notifications are not reordered. Must be called with the lock held.
+func (c *Cache) notify(old, new interface{}) {
+	c.notifying.Lock()
+	c.mu.Unlock()
+	defer c.notifying.Unlock()
+	if c.onUpdate != nil {
+		c.onUpdate(old, new)
+	}
+}
+
+// Expire marks the cached value as stale, so that it's refreshed
+// in the background on the next request while still being served.
+func (c *Cache) Expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updated = time.Time{}
+	c.failed = time.Time{}
+	c.err = nil
+}
+
+// Get returns the cached value, fetching it if the cache is empty.
+// A stale value is returned immediately and refreshed in the background.
+func (c *Cache) Get() (interface{}, error) {
+	c.mu.Lock()
+	if c.valid {
+		if c.expired() {
+			c.refresh()
+		}
+		v := c.value
+		c.mu.Unlock()
+		return v, nil
+	}
+
+	// Nothing to serve yet: wait for the fetch, unless the last one
+	// failed so recently that trying again would hammer the upstream
+	if c.inflight == nil && c.err != nil && time.Since(c.failed) < c.retryInterval() {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	done := c.refresh()
+	c.mu.Unlock()
+	<-done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.valid {
+		return c.value, nil
+	}
+	return nil, c.err
+}
+
+// Refresh fetches a new value, or waits for the fetch running, and
+// returns it. The old value is kept if the fetch fails.
+func (c *Cache) Refresh() (interface{}, error) {
+	c.mu.Lock()
+	done := c.refresh()
+	c.mu.Unlock()
+	<-done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.value, nil
+}
+
+// expired returns whether the value needs to be refreshed.
+// Must be called with the lock held.
+func (c *Cache) expired() bool {
+	if time.Since(c.updated) < c.ttl {
+		return false
+	}
+	return c.failed.Before(c.updated) || time.Since(c.failed) >= c.retryInterval()
+}
+
+// retryInterval returns how long to wait after a failed fetch.
+func (c *Cache) retryInterval() time.Duration {
+	interval := c.ttl
+	if interval > maxRetryInterval {
+		interval = maxRetryInterval
+	}
+	if e, ok := c.err.(retryAfterer); ok && e.RetryAfter() > interval {
+		interval = e.RetryAfter()
+	}
+	return interval
+}
+
+// refresh starts fetching a new value unless a fetch is already running,
+// and returns a channel closed when it is done.
+// Must be called with the lock held.
+func (c *Cache) refresh() chan struct{} {
+	if c.inflight != nil {
+		return c.inflight
+	}
+	done := make(chan struct{})
+	c.inflight = done
+	go func() {
+		v, err := c.fetch()
+
+		c.mu.Lock()
+		c.inflight = nil
+		defer close(done)
+		if err != nil {
+			c.err = err
+			c.failed = time.Now()
+			if c.valid {
+				log.Printf("Failed to refresh cache, serving stale data: %v", err)
+			}
+			c.mu.Unlock()
+			return
+		}
+		old := c.value
+		c.value, c.valid, c.err = v, true, nil
+		c.updated = time.Now()
+		c.notify(old, v)
+	}()
+	return done
+}
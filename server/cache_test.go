@@ -0,0 +1,224 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is a fetch function returning how many times it was called.
+type counter struct {
+	mu    sync.Mutex
+	calls int
+	fail  bool
+}
+
+func (c *counter) fetch() (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.fail {
+		return nil, errors.New("upstream is down")
+	}
+	return c.calls, nil
+}
+
+func (c *counter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *counter) setFail(fail bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fail = fail
+}
+
+// waitForCalls waits for background refreshes to reach n calls.
+func waitForCalls(t *testing.T, c *counter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for c.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d fetches, got %d", n, c.count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheFresh(t *testing.T) {
+	c := &counter{}
+	cache := NewCache(time.Hour, c.fetch)
+	for i := 0; i < 10; i++ {
+		v, err := cache.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.(int) != 1 {
+			t.Fatalf("expected cached value 1, got %v", v)
+		}
+	}
+	if c.count() != 1 {
+		t.Fatalf("expected 1 fetch, got %d", c.count())
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	c := &counter{}
+	cache := NewCache(10*time.Millisecond, c.fetch)
+	if _, err := cache.Get(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The stale value is served while refreshing in the background
+	v, err := cache.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(int) != 1 {
+		t.Fatalf("expected stale value 1, got %v", v)
+	}
+	waitForCalls(t, c, 2)
+	time.Sleep(time.Millisecond)
+	if v, _ := cache.Get(); v.(int) != 2 {
+		t.Fatalf("expected refreshed value 2, got %v", v)
+	}
+}
+
+func TestCacheServeStaleOnError(t *testing.T) {
+	c := &counter{}
+	cache := NewCache(10*time.Millisecond, c.fetch)
+	if _, err := cache.Get(); err != nil {
+		t.Fatal(err)
+	}
+	c.setFail(true)
+	time.Sleep(20 * time.Millisecond)
+	cache.Get()
+	waitForCalls(t, c, 2)
+
+	v, err := cache.Get()
+	if err != nil {
+		t.Fatalf("expected stale value, got error %v", err)
+	}
+	if v.(int) != 1 {
+		t.Fatalf("expected stale value 1, got %v", v)
+	}
+}
+
+func TestCacheError(t *testing.T) {
+	c := &counter{fail: true}
+	cache := NewCache(time.Hour, c.fetch)
+	if _, err := cache.Get(); err == nil {
+		t.Fatal("expected an error from an empty cache")
+	}
+
+	// Failures are not retried right away
+	if _, err := cache.Get(); err == nil {
+		t.Fatal("expected the previous error")
+	}
+	if c.count() != 1 {
+		t.Fatalf("expected 1 fetch, got %d", c.count())
+	}
+}
+
+// rateLimited is an error asking to wait before trying again.
+type rateLimited time.Duration
+
+func (e rateLimited) Error() string {
+	return "rate limited"
+}
+
+func (e rateLimited) RetryAfter() time.Duration {
+	return time.Duration(e)
+}
+
+func TestCacheRetryAfter(t *testing.T) {
+	calls := 0
+	cache := NewCache(time.Millisecond, func() (interface{}, error) {
+		calls++
+		return nil, rateLimited(time.Hour)
+	})
+	cache.Get()
+	time.Sleep(5 * time.Millisecond)
+
+	// The error is served until the upstream allows trying again
+	if _, err := cache.Get(); err == nil {
+		t.Fatal("expected the cached error")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 fetch, got %d", calls)
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	c := &counter{}
+	cache := NewCache(time.Hour, c.fetch)
+	cache.Get()
+	if v, err := cache.Refresh(); err != nil || v.(int) != 2 {
+		t.Fatalf("expected refreshed value 2, got %v, %v", v, err)
+	}
+
+	c.setFail(true)
+	if _, err := cache.Refresh(); err == nil {
+		t.Fatalf("expected the failure to be reported")
+	}
+	if v, _ := cache.Get(); v.(int) != 2 {
+		t.Fatalf("expected the old value to be kept, got %v", v)
+	}
+}
+
+func TestCacheUpdate(t *testing.T) {
+	c := &counter{}
+	cache := NewCache(time.Hour, c.fetch)
+	var updates [][2]interface{}
+	cache.OnUpdate(func(old, new interface{}) {
+		updates = append(updates, [2]interface{}{old, new})
+	})
+	if cache.Update(func(v interface{}) interface{} { return v }) {
+		t.Fatalf("expected nothing to update")
+	}
+
+	cache.Get()
+	cache.Update(func(v interface{}) interface{} {
+		return v.(int) + 10
+	})
+	if v, _ := cache.Get(); v.(int) != 11 {
+		t.Fatalf("expected updated value 11, got %v", v)
+	}
+	cache.Refresh()
+
+	expected := [][2]interface{}{{nil, 1}, {1, 11}, {11, 2}}
+	if len(updates) != len(expected) {
+		t.Fatalf("expected updates %v, got %v", expected, updates)
+	}
+	for i := range expected {
+		if updates[i] != expected[i] {
+			t.Errorf("update %d: expected %v, got %v", i, expected[i], updates[i])
+		}
+	}
+}
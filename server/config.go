@@ -0,0 +1,332 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	invite "github.com/lirios/website/invite"
+	slack "github.com/lirios/website/slack"
+)
+
+// Problem is an invalid setting.
+type Problem struct {
+	// Pos is where the setting was given, if known.
+	Pos        string
+	Section    string
+	Subsection string
+	Name       string
+	Message    string
+}
+
+func (p Problem) Error() string {
+	var s string
+	if p.Pos != "" {
+		s = p.Pos + ": "
+	}
+	if p.Section != "" {
+		s += "[" + p.Section
+		if p.Subsection != "" {
+			s += " " + quote(p.Subsection)
+		}
+		s += "] "
+		if p.Name != "" {
+			s += p.Name + ": "
+		}
+	}
+	return s + p.Message
+}
+
+// Problems is a list of invalid settings.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	messages := make([]string, len(p))
+	for i, problem := range p {
+		messages[i] = problem.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// err returns the problems as an error, or nil if there are none.
+func (p Problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Validate checks the settings for values that would prevent
+// the server from working.
+func (s *Settings) Validate() error {
+	return s.Check().err()
+}
+
+// TeamSortKeys are the keys team members can be sorted by.
+var TeamSortKeys = []string{"role", "name", "joined", "presence", "tz"}
+
+// Privacy policies: with opt-in only the members listed in optin are
+// published, with opt-out all of them except those listed in optout.
+const (
+	PrivacyOptIn  = "opt-in"
+	PrivacyOptOut = "opt-out"
+)
+
+// Publication rules of the fields of team members.
+const (
+	Publish       = "publish"
+	PublishOffset = "offset"
+	Hide          = "hide"
+)
+
+// MemberRoles are the roles of team members, from the most privileged.
+var MemberRoles = []string{"owner", "admin", "member", "guest"}
+
+// isTeamSortKey returns whether members can be sorted by key.
+func isTeamSortKey(key string) bool {
+	for _, k := range TeamSortKeys {
+		if k == key {
+			return true
+		}
+	}
+	return key == ""
+}
+
+// Check returns all the problems found in the settings.
+func (s *Settings) Check() Problems {
+	var problems Problems
+	add := func(section, subsection, name, message string) {
+		problems = append(problems, Problem{Section: section, Subsection: subsection, Name: name, Message: message})
+	}
+	checkURL := func(section, subsection, name, value string) {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			add(section, subsection, name, "invalid url "+quote(value))
+		}
+	}
+	checkFile := func(section, name, value string) {
+		if _, err := os.Stat(value); err != nil {
+			add(section, "", name, err.Error())
+		}
+	}
+
+	if s.Server.Port != "" {
+		if _, _, err := net.SplitHostPort(s.Server.Port); err != nil {
+			add("server", "", "port", "invalid port "+quote(s.Server.Port))
+		}
+	}
+	for _, proxy := range s.Server.TrustedProxy {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server", "", "trustedproxy", "invalid address "+quote(proxy))
+		}
+	}
+	durations := []struct {
+		section, name string
+		value         Duration
+	}{
+		{"server", "readtimeout", s.Server.ReadTimeout},
+		{"server", "writetimeout", s.Server.WriteTimeout},
+		{"server", "idletimeout", s.Server.IdleTimeout},
+		{"server", "shutdowntimeout", s.Server.ShutdownTimeout},
+		{"team", "cachettl", s.Team.CacheTTL},
+		{"team", "pollinterval", s.Team.PollInterval},
+		{"github", "cachettl", s.GitHub.CacheTTL},
+		{"releases", "cachettl", s.Releases.CacheTTL},
+		{"download", "checkinterval", s.Download.CheckInterval},
+		{"download", "maxlag", s.Download.MaxLag},
+		{"invite", "ratewindow", s.Invite.RateWindow},
+	}
+	for _, d := range durations {
+		if d.value.Duration < 0 {
+			add(d.section, "", d.name, "negative duration "+d.value.String())
+		}
+	}
+
+	switch s.Team.Provider {
+	case "", "slack":
+		checkURL("slack", "", "url", s.Slack.URL)
+		if s.Slack.Token == "" {
+			add("slack", "", "token", "missing token")
+		}
+		if s.Slack.PageSize < 0 || s.Slack.PageSize > slack.MaxPageSize {
+			add("slack", "", "pagesize", fmt.Sprintf("page size must be between 1 and %d", slack.MaxPageSize))
+		}
+	case "matrix":
+		checkURL("matrix", "", "homeserver", s.Matrix.Homeserver)
+		if s.Matrix.Token == "" {
+			add("matrix", "", "token", "missing token")
+		}
+		if s.Matrix.Room == "" {
+			add("matrix", "", "room", "missing room")
+		}
+		if s.Matrix.IdentityServer != "" {
+			checkURL("matrix", "", "identityserver", s.Matrix.IdentityServer)
+			if s.Matrix.IdentityToken == "" {
+				add("matrix", "", "identitytoken", "missing identity server token")
+			}
+		}
+	default:
+		add("team", "", "provider", "unknown provider "+quote(s.Team.Provider))
+	}
+
+	if s.Team.MaxSubscribers < 0 {
+		add("team", "", "maxsubscribers", "negative number of subscribers")
+	}
+	for _, key := range strings.Split(s.Team.Sort, ",") {
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		if !isTeamSortKey(key) {
+			add("team", "", "sort", "unknown sort key "+quote(key))
+		}
+	}
+
+	switch s.Privacy.Policy {
+	case "", PrivacyOptIn, PrivacyOptOut:
+	default:
+		add("privacy", "", "policy", "unknown policy "+quote(s.Privacy.Policy))
+	}
+	rules := []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"realname", s.Privacy.RealName, []string{Publish, Hide}},
+		{"tz", s.Privacy.Tz, []string{Publish, PublishOffset, Hide}},
+		{"presence", s.Privacy.Presence, []string{Publish, Hide}},
+		{"image", s.Privacy.Image, []string{Publish, Hide}},
+	}
+	for _, rule := range rules {
+		if rule.value != "" && !contains(rule.allowed, rule.value) {
+			add("privacy", "", rule.name, "unknown rule "+quote(rule.value)+", expected one of "+strings.Join(rule.allowed, ", "))
+		}
+	}
+
+	if s.Invite.RateLimit < 0 {
+		add("invite", "", "ratelimit", "negative rate limit")
+	}
+	if s.Invite.ProofOfWork < 0 || s.Invite.ProofOfWork > invite.MaxDifficulty {
+		add("invite", "", "proofofwork", fmt.Sprintf("difficulty must be between 0 and %d", invite.MaxDifficulty))
+	}
+
+	if s.Avatars.Directory == "" {
+		add("avatars", "", "directory", "missing directory")
+	}
+	if s.Avatars.MaxSize < 0 {
+		add("avatars", "", "maxsize", "negative size")
+	}
+	if s.Avatars.BaseURL != "" {
+		checkURL("avatars", "", "baseurl", s.Avatars.BaseURL)
+	}
+
+	checkURL("github", "", "url", s.GitHub.URL)
+	if s.GitHub.Organization == "" {
+		add("github", "", "organization", "missing organization")
+	}
+
+	if s.Releases.Manifest != "" && s.Releases.Directory != "" {
+		add("releases", "", "", "manifest and directory are mutually exclusive")
+	}
+	if s.Releases.Manifest != "" {
+		checkFile("releases", "manifest", s.Releases.Manifest)
+	}
+	if s.Releases.Directory != "" {
+		checkFile("releases", "directory", s.Releases.Directory)
+	}
+	if s.Releases.BaseURL != "" {
+		if _, err := url.Parse(s.Releases.BaseURL); err != nil {
+			add("releases", "", "baseurl", "invalid url "+quote(s.Releases.BaseURL))
+		}
+	}
+	if s.Download.GeoIP != "" {
+		checkFile("download", "geoip", s.Download.GeoIP)
+	}
+
+	names := make([]string, 0, len(s.Mirror))
+	for name := range s.Mirror {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := s.Mirror[name]
+		if m.URL == "" {
+			add("mirror", name, "url", "missing url")
+		} else {
+			checkURL("mirror", name, "url", m.URL)
+		}
+		for _, alt := range m.AltURL {
+			checkURL("mirror", name, "alturl", alt)
+		}
+		if m.Weight < 0 {
+			add("mirror", name, "weight", "negative weight")
+		}
+		if m.Country != "" && len(m.Country) != 2 {
+			add("mirror", name, "country", "invalid country code "+quote(m.Country))
+		}
+		if m.Continent != "" && len(m.Continent) != 2 {
+			add("mirror", name, "continent", "invalid continent code "+quote(m.Continent))
+		}
+	}
+	checkMembers := func(section string, members map[string]*MemberSettings) {
+		ids := make([]string, 0, len(members))
+		for id := range members {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			m := members[id]
+			if section == "extra" && !m.Hide && m.Name == "" {
+				add(section, id, "name", "missing name")
+			}
+			if m.Image != "" {
+				checkURL(section, id, "image", m.Image)
+			}
+			if m.Website != "" {
+				checkURL(section, id, "website", m.Website)
+			}
+			if m.Role != "" && !isMemberRole(m.Role) {
+				add(section, id, "role", "unknown role "+quote(m.Role))
+			}
+		}
+	}
+	checkMembers("member", s.Member)
+	checkMembers("extra", s.Extra)
+	return problems
+}
+
+// isMemberRole returns whether role is one of MemberRoles.
+func isMemberRole(role string) bool {
+	return contains(MemberRoles, role)
+}
+
+// contains returns whether s is in list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -23,17 +23,199 @@
 
 package server
 
+import (
+	"time"
+)
+
+// Duration is a time.Duration read from a configuration file
+// with the syntax understood by time.ParseDuration, such as "5m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses a duration from the configuration file.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalText writes a duration the way it is read.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// OrDefault returns the duration, or def when it is not set.
+func (d Duration) OrDefault(def time.Duration) time.Duration {
+	if d.Duration <= 0 {
+		return def
+	}
+	return d.Duration
+}
+
+// Date is a day, such as 2017-03-21, that can be read from the configuration.
+type Date struct {
+	time.Time
+}
+
+// UnmarshalText parses a date from the configuration file.
+func (d *Date) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		d.Time = time.Time{}
+		return nil
+	}
+	v, err := time.Parse("2006-01-02", string(text))
+	if err != nil {
+		return err
+	}
+	d.Time = v
+	return nil
+}
+
+// MarshalText writes a date the way it is read.
+func (d Date) MarshalText() ([]byte, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return []byte(d.Format("2006-01-02")), nil
+}
+
+// MirrorSettings describes a download mirror.
+type MirrorSettings struct {
+	URL       string
+	AltURL    []string
+	Weight    int
+	Country   string
+	Continent string
+}
+
+// MemberSettings contains curated information about a team member,
+// replacing what the chat service says. It describes a member missing
+// from the chat service when used for an extra entry.
+type MemberSettings struct {
+	Hide     bool
+	Name     string
+	RealName string
+	Image    string
+	Title    string
+	Role     string
+	Joined   Date
+	GitHub   string
+	Mastodon string
+	Website  string
+}
+
+// Overrides contains curated information about the team, read from the
+// file given by the overrides setting of the team section, in the format
+// of the settings file.
+type Overrides struct {
+	Member map[string]*MemberSettings
+	Extra  map[string]*MemberSettings
+}
+
 // Settings contains settings from a configuration file.
+// Fields tagged as secret are never printed, and can be read from the file
+// named by the field of the same name with a File suffix.
 type Settings struct {
 	Server struct {
-		Port string
+		Port       string
+		TrustProxy bool
+		// TrustedProxy lists the addresses or networks of the proxies
+		// allowed to tell the address of the clients, any when empty.
+		TrustedProxy []string
+		ReadTimeout  Duration
+		// WriteTimeout bounds the responses, except the streams.
+		WriteTimeout    Duration
+		IdleTimeout     Duration
+		ShutdownTimeout Duration
+	}
+	Team struct {
+		Provider  string
+		CacheTTL  Duration
+		Sort      string
+		Overrides string
+		// PollInterval is how often presence is checked
+		// while clients are listening to the team events.
+		PollInterval   Duration
+		MaxSubscribers int
 	}
 	Slack struct {
-		Token string
+		URL       string
+		Token     string `secret:"true"`
+		TokenFile string `gcfg:"token-file"`
+		PageSize  int
+		// SigningSecret verifies the requests of the Events API.
+		SigningSecret     string `secret:"true"`
+		SigningSecretFile string `gcfg:"signingsecret-file"`
+	}
+	Matrix struct {
+		Homeserver string
+		Token      string `secret:"true"`
+		TokenFile  string `gcfg:"token-file"`
+		Room       string
+		// IdentityServer and IdentityToken send the invitations by email.
+		IdentityServer    string
+		IdentityToken     string `secret:"true"`
+		IdentityTokenFile string `gcfg:"identitytoken-file"`
+	}
+	Privacy struct {
+		Policy         string
+		OptIn          []string
+		OptOut         []string
+		RealName       string
+		Tz             string
+		Presence       string
+		Image          string
+		AuditToken     string `secret:"true"`
+		AuditTokenFile string `gcfg:"audittoken-file"`
+	}
+	Invite struct {
+		Enabled   bool
+		RateLimit int
+		// RateWindow is the period RateLimit invitations are allowed in.
+		RateWindow Duration
+		// ProofOfWork is the difficulty of the challenge, in bits,
+		// or 0 to ask for none.
+		ProofOfWork int
+		Blocklist   []string
+	}
+	Avatars struct {
+		Directory string
+		BaseURL   string
+		// MaxSize is how many megabytes the cached avatars take.
+		MaxSize int
+	}
+	GitHub struct {
+		URL          string
+		Organization string
+		Token        string `secret:"true"`
+		TokenFile    string `gcfg:"token-file"`
+		CacheTTL     Duration
+	}
+	Releases struct {
+		Manifest  string
+		Directory string
+		BaseURL   string
+		CacheTTL  Duration
+	}
+	Download struct {
+		GeoIP         string
+		CheckInterval Duration
+		Trace         string
+		MaxLag        Duration
 	}
+	Mirror map[string]*MirrorSettings
+	Member map[string]*MemberSettings
+	Extra  map[string]*MemberSettings
 }
 
 // Context interface.
 type Context interface {
 	Settings() *Settings
+	TeamCache() *Cache
+	ContributorsCache() *Cache
+	ReleasesCache() *Cache
 }
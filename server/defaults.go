@@ -0,0 +1,84 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"time"
+
+	avatars "github.com/lirios/website/avatars"
+	events "github.com/lirios/website/events"
+	mirrors "github.com/lirios/website/mirrors"
+	slack "github.com/lirios/website/slack"
+)
+
+// Defaults for settings missing from the configuration.
+const (
+	DefaultPort               = ":8080"
+	DefaultReadTimeout        = 30 * time.Second
+	DefaultWriteTimeout       = 5 * time.Minute
+	DefaultIdleTimeout        = 2 * time.Minute
+	DefaultShutdownTimeout    = 30 * time.Second
+	DefaultTeamProvider       = "slack"
+	DefaultTeamSort           = "role,name"
+	DefaultTeamPollInterval   = 30 * time.Second
+	DefaultSlackURL           = "https://slack.com/api"
+	DefaultPrivacyPolicy      = PrivacyOptOut
+	DefaultInviteRateLimit    = 3
+	DefaultInviteRateWindow   = time.Hour
+	DefaultGitHubURL          = "https://api.github.com"
+	DefaultGitHubOrganization = "lirios"
+)
+
+// Defaults returns the settings used when nothing else is configured.
+func Defaults() *Settings {
+	s := &Settings{}
+	s.Server.Port = DefaultPort
+	s.Server.ReadTimeout.Duration = DefaultReadTimeout
+	s.Server.WriteTimeout.Duration = DefaultWriteTimeout
+	s.Server.IdleTimeout.Duration = DefaultIdleTimeout
+	s.Server.ShutdownTimeout.Duration = DefaultShutdownTimeout
+	s.Team.Provider = DefaultTeamProvider
+	s.Team.CacheTTL.Duration = DefaultCacheTTL
+	s.Team.Sort = DefaultTeamSort
+	s.Team.PollInterval.Duration = DefaultTeamPollInterval
+	s.Team.MaxSubscribers = events.DefaultMaxSubscribers
+	s.Slack.URL = DefaultSlackURL
+	s.Slack.PageSize = slack.DefaultPageSize
+	s.Privacy.Policy = DefaultPrivacyPolicy
+	s.Privacy.RealName = Publish
+	s.Privacy.Tz = Publish
+	s.Privacy.Presence = Publish
+	s.Privacy.Image = Publish
+	s.Invite.RateLimit = DefaultInviteRateLimit
+	s.Invite.RateWindow.Duration = DefaultInviteRateWindow
+	s.Avatars.Directory = avatars.DefaultDirectory
+	s.Avatars.MaxSize = avatars.DefaultMaxSize >> 20
+	s.GitHub.URL = DefaultGitHubURL
+	s.GitHub.Organization = DefaultGitHubOrganization
+	s.GitHub.CacheTTL.Duration = DefaultCacheTTL
+	s.Releases.CacheTTL.Duration = DefaultCacheTTL
+	s.Download.CheckInterval.Duration = mirrors.DefaultInterval
+	s.Download.MaxLag.Duration = mirrors.DefaultMaxLag
+	return s
+}
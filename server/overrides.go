@@ -0,0 +1,57 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+// ReadOverrides reads the team overrides file, if any, and merges it
+// into the settings: an entry of the file replaces the one with the same
+// identifier in the settings. The file is read every time the settings
+// are loaded, so that it can be changed with a reload.
+func (s *Settings) ReadOverrides() (Positions, error) {
+	if s.Team.Overrides == "" {
+		return Positions{}, nil
+	}
+	var overrides Overrides
+	positions, err := readFile(&overrides, s.Team.Overrides)
+	if _, ok := err.(Problems); err != nil && !ok {
+		return nil, Problems{{Section: "team", Name: "overrides", Message: err.Error()}}
+	}
+	s.Member = mergeMembers(s.Member, overrides.Member)
+	s.Extra = mergeMembers(s.Extra, overrides.Extra)
+	return positions, err
+}
+
+// mergeMembers adds the entries of b to a, replacing those with the same
+// identifier.
+func mergeMembers(a, b map[string]*MemberSettings) map[string]*MemberSettings {
+	if len(b) == 0 {
+		return a
+	}
+	if a == nil {
+		a = make(map[string]*MemberSettings, len(b))
+	}
+	for id, m := range b {
+		a[id] = m
+	}
+	return a
+}
@@ -0,0 +1,85 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadOverrides(t *testing.T) {
+	f, err := ioutil.TempFile("", "overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[member "U1"]
+hide = true
+
+[extra "pier"]
+name = plfiorini
+realname = Pier Luigi Fiorini
+joined = 2016-01-01
+
+[extra "broken"]
+joined = yesterday
+`)
+	f.Close()
+
+	settings := Defaults()
+	settings.Slack.Token = "xoxb-test"
+	settings.Team.Overrides = f.Name()
+	settings.Member = map[string]*MemberSettings{
+		"U1": {Title: "Founder"},
+		"U2": {Title: "Design"},
+	}
+	positions, err := settings.ReadOverrides()
+	problems, ok := err.(Problems)
+	if !ok || len(problems) != 1 || problems[0].Pos != f.Name()+":10:1" {
+		t.Fatalf("expected a problem with the date at line 10, got %v", err)
+	}
+
+	// The file replaces the entries of the settings
+	if m := settings.Member["U1"]; !m.Hide || m.Title != "" {
+		t.Errorf("expected U1 to be replaced, got %+v", m)
+	}
+	if m := settings.Member["U2"]; m.Title != "Design" {
+		t.Errorf("expected U2 to be kept, got %+v", m)
+	}
+	if m := settings.Extra["pier"]; m == nil || m.RealName != "Pier Luigi Fiorini" || m.Joined.Year() != 2016 {
+		t.Errorf("unexpected extra entry %+v", m)
+	}
+
+	// Problems found later are located in the file
+	located := positions.Locate(settings.Check())
+	if len(located) != 1 || located[0].Subsection != "broken" || located[0].Pos != f.Name()+":9:1" {
+		t.Errorf("expected the missing name of the broken entry, got %v", located)
+	}
+
+	settings.Team.Overrides = "/nonexistent/overrides.ini"
+	if _, err := settings.ReadOverrides(); err == nil {
+		t.Error("expected an error for a missing overrides file")
+	}
+}
@@ -0,0 +1,202 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gcfg.v1"
+	"gopkg.in/gcfg.v1/scanner"
+	"gopkg.in/gcfg.v1/token"
+	"gopkg.in/warnings.v0"
+)
+
+// settingKey identifies a setting, or a section when the name is empty.
+type settingKey struct {
+	section, subsection, name string
+}
+
+func newSettingKey(section, subsection, name string) settingKey {
+	return settingKey{strings.ToLower(section), subsection, strings.ToLower(name)}
+}
+
+// Positions records where the settings were given in the settings file.
+type Positions map[settingKey]string
+
+// Locate sets the position of the problems found in the settings file.
+func (p Positions) Locate(problems Problems) Problems {
+	for i, problem := range problems {
+		if problem.Pos != "" {
+			continue
+		}
+		if pos, ok := p[newSettingKey(problem.Section, problem.Subsection, problem.Name)]; ok {
+			problems[i].Pos = pos
+		} else if pos, ok := p[newSettingKey(problem.Section, problem.Subsection, "")]; ok {
+			problems[i].Pos = pos
+		}
+	}
+	return problems
+}
+
+// Merge adds the positions of another file.
+func (p Positions) Merge(other Positions) {
+	for key, pos := range other {
+		p[key] = pos
+	}
+}
+
+// Override records that a setting was given outside of the settings file,
+// such as "$SLACK_TOKEN" for the environment.
+func (p Positions) Override(v Variable, pos string) {
+	p[newSettingKey(v.Section, "", v.Name)] = pos
+}
+
+// readError simplifies the errors returned by gcfg, which reports
+// unknown sections and variables as a list of warnings.
+func readError(err error) error {
+	if _, ok := err.(warnings.List); ok {
+		return errors.New("unknown setting")
+	}
+	return err
+}
+
+// line is a line of the settings file split into tokens.
+type line struct {
+	pos  token.Position
+	toks []token.Token
+	lits []string
+}
+
+// is returns whether the line is made of the given tokens.
+func (l line) is(toks ...token.Token) bool {
+	if len(l.toks) != len(toks) {
+		return false
+	}
+	for i := range toks {
+		if l.toks[i] != toks[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadFile reads the settings file into s. Unlike gcfg it carries on
+// past invalid lines and values so that all of them are reported,
+// with their position, as Problems. It also returns where each setting
+// was given, to locate the problems found later by Check.
+func (s *Settings) ReadFile(fileName string) (Positions, error) {
+	return readFile(s, fileName)
+}
+
+// readFile reads a file in the format of the settings file into
+// config, which is a pointer to a struct, as ReadFile.
+func readFile(config interface{}, fileName string) (Positions, error) {
+	src, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems Problems
+	fset := token.NewFileSet()
+	file := fset.AddFile(fileName, fset.Base(), len(src))
+	var sc scanner.Scanner
+	sc.Init(file, src, func(pos token.Position, msg string) {
+		problems = append(problems, Problem{Pos: pos.String(), Message: msg})
+	}, 0)
+
+	positions := Positions{}
+	var header, section, subsection string
+	known := false
+	for done := false; !done; {
+		// Read a line, skipping it if the scanner reports an error
+		n := len(problems)
+		var l line
+		for {
+			pos, tok, lit := sc.Scan()
+			if tok == token.EOF {
+				done = true
+			}
+			if tok == token.EOF || tok == token.EOL {
+				break
+			}
+			if len(l.toks) == 0 {
+				l.pos = fset.Position(pos)
+			}
+			l.toks = append(l.toks, tok)
+			l.lits = append(l.lits, lit)
+		}
+
+		if len(l.toks) == 0 || len(problems) > n {
+			continue
+		}
+
+		problem := Problem{Pos: l.pos.String()}
+		switch {
+		case l.is(token.LBRACK, token.IDENT, token.RBRACK), l.is(token.LBRACK, token.IDENT, token.STRING, token.RBRACK):
+			section, subsection = l.lits[1], ""
+			header = "[" + section + "]"
+			if len(l.toks) == 4 {
+				subsection, _ = strconv.Unquote(l.lits[2])
+				header = "[" + section + " " + l.lits[2] + "]"
+			}
+			problem.Section, problem.Subsection = section, subsection
+			positions[newSettingKey(section, subsection, "")] = problem.Pos
+			if err := gcfg.ReadStringInto(config, header); err != nil {
+				problem.Message = "unknown section"
+				problems = append(problems, problem)
+				known = false
+			} else {
+				known = true
+			}
+		case l.is(token.IDENT), l.is(token.IDENT, token.ASSIGN, token.STRING):
+			if header == "" {
+				problem.Message = "expected section header"
+				problems = append(problems, problem)
+				continue
+			}
+			if !known {
+				// Already reported with the section
+				continue
+			}
+			name := l.lits[0]
+			variable := name
+			if len(l.toks) == 3 {
+				variable += " = " + l.lits[2]
+			}
+			problem.Section, problem.Subsection, problem.Name = section, subsection, name
+			positions[newSettingKey(section, subsection, name)] = problem.Pos
+			if err := gcfg.ReadStringInto(config, header+"\n"+variable+"\n"); err != nil {
+				problem.Message = readError(err).Error()
+				problems = append(problems, problem)
+			}
+		default:
+			problem.Message = "expected section header or variable declaration"
+			problems = append(problems, problem)
+		}
+	}
+	return positions, problems.err()
+}
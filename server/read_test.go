@@ -0,0 +1,120 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`; comment
+[server]
+readTimeout = 10s
+writetimeout = later
+colour = blue
+[nonexistent]
+key = value
+[mirror "main"]
+alturl = rsync://mirror.example.org
+weight = -1
+garbage ]
+`)
+	f.Close()
+
+	settings := Defaults()
+	positions, err := settings.ReadFile(f.Name())
+	problems, ok := err.(Problems)
+	if !ok {
+		t.Fatalf("expected problems, got %v", err)
+	}
+	expected := []string{
+		f.Name() + ":4:1: [server] writetimeout: time: invalid duration \"later\"",
+		f.Name() + ":5:1: [server] colour: unknown setting",
+		f.Name() + ":6:1: [nonexistent] unknown section",
+		f.Name() + ":11:1: expected section header or variable declaration",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got:\n%v", len(expected), problems)
+	}
+	for i := range expected {
+		if problems[i].Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], problems[i].Error())
+		}
+	}
+
+	// Valid lines are applied anyway
+	if settings.Server.ReadTimeout.Seconds() != 10 {
+		t.Errorf("expected a 10s read timeout, got %v", settings.Server.ReadTimeout)
+	}
+	m := settings.Mirror["main"]
+	if m == nil || len(m.AltURL) != 1 || m.Weight != -1 {
+		t.Fatalf("unexpected mirror %+v", m)
+	}
+
+	located := positions.Locate(settings.Check())
+	found := false
+	for _, problem := range located {
+		switch problem.Name {
+		case "url":
+			found = true
+			if problem.Pos != f.Name()+":8:1" {
+				t.Errorf("expected the missing url at the section header, got %q", problem.Pos)
+			}
+		case "weight":
+			if problem.Pos != f.Name()+":10:1" {
+				t.Errorf("expected the weight at line 10, got %q", problem.Pos)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the missing mirror url to be reported, got %v", located)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	settings := Defaults()
+	settings.Team.Provider = "matrix"
+	settings.Matrix.Homeserver = "matrix.org"
+	problems := settings.Check()
+	expected := map[string]bool{
+		"[matrix] homeserver: invalid url \"matrix.org\"": true,
+		"[matrix] token: missing token":                   true,
+		"[matrix] room: missing room":                     true,
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got:\n%v", len(expected), problems)
+	}
+	for _, problem := range problems {
+		if !expected[problem.Error()] {
+			t.Errorf("unexpected problem %q", problem.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandlerFunc is a http handler of the application.
+//
+// It returns the status code and the body of the response, which is
+// marshalled to JSON unless it is a []byte, in which case it's written as is
+// with the Content-Type set by the handler. A nil body writes no content,
+// which suits codes such as 204, 304 and redirects.
+// Errors are rendered as JSON with the status code of an *Error,
+// any other error is logged and reported as an internal error.
+type HandlerFunc func(c Context, w http.ResponseWriter, r *http.Request) (int, interface{}, error)
+
+// Error is an error returned by a handler.
+type Error struct {
+	Code    int         `json:"-"`
+	ID      string      `json:"error"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewError returns an error with a status code, a machine-readable
+// identifier and a message meant for humans.
+func NewError(code int, id, message string) *Error {
+	return &Error{Code: code, ID: id, Message: message}
+}
+
+// Error returns the message.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithDetails returns a copy of the error with additional information.
+func (e *Error) WithDetails(details interface{}) *Error {
+	result := *e
+	result.Details = details
+	return &result
+}
+
+// BadRequest returns an error for an invalid request.
+func BadRequest(message string) *Error {
+	return NewError(http.StatusBadRequest, "bad_request", message)
+}
+
+// Unauthorized returns an error for a request lacking valid credentials.
+func Unauthorized(message string) *Error {
+	return NewError(http.StatusUnauthorized, "unauthorized", message)
+}
+
+// NotFound returns an error for a missing resource.
+func NotFound(message string) *Error {
+	return NewError(http.StatusNotFound, "not_found", message)
+}
+
+// Unavailable returns an error for a service that can't be provided now.
+func Unavailable(message string) *Error {
+	return NewError(http.StatusServiceUnavailable, "unavailable", message)
+}
+
+// UpstreamError returns an error for a failure of a remote service.
+func UpstreamError(err error) *Error {
+	return NewError(http.StatusBadGateway, "upstream_error", err.Error())
+}
+
+// StatusWritten is returned by handlers that wrote the response
+// themselves, such as event streams, for Respond to leave it alone.
+const StatusWritten = -1
+
+// errorData is the response to a failed request.
+type errorData struct {
+	Ok bool `json:"ok"`
+	*Error
+}
+
+// Respond writes the result of a handler to w.
+func Respond(w http.ResponseWriter, r *http.Request, code int, body interface{}, err error) {
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			e = NewError(http.StatusInternalServerError, "internal_error", "internal server error")
+		}
+		code, body = e.Code, errorData{Ok: false, Error: e}
+	}
+	if code == StatusWritten {
+		return
+	}
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	switch data := body.(type) {
+	case nil:
+		w.WriteHeader(code)
+	case []byte:
+		w.WriteHeader(code)
+		w.Write(data)
+	default:
+		finalJSON, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(finalJSON)
+	}
+}
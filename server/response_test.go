@@ -0,0 +1,86 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func respond(code int, body interface{}, err error) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Respond(w, httptest.NewRequest("GET", "/", nil), code, body, err)
+	return w
+}
+
+func TestRespondJSON(t *testing.T) {
+	w := respond(http.StatusCreated, map[string]bool{"ok": true}, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+	if w.Body.String() != `{"ok":true}` {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+
+	if w := respond(0, []int{}, nil); w.Code != http.StatusOK {
+		t.Errorf("expected status 200 by default, got %d", w.Code)
+	}
+}
+
+func TestRespondWithoutBody(t *testing.T) {
+	w := respond(http.StatusNotModified, nil, nil)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRespondRaw(t *testing.T) {
+	w := respond(http.StatusOK, []byte("<svg/>"), nil)
+	if w.Body.String() != "<svg/>" || w.Header().Get("Content-Type") == "application/json" {
+		t.Errorf("unexpected raw response %q", w.Body.String())
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	err := NotFound("release not found").WithDetails(map[string]string{"version": "0.1"})
+	w := respond(http.StatusOK, "ignored", err)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+	expected := `{"ok":false,"error":"not_found","message":"release not found","details":{"version":"0.1"}}`
+	if w.Body.String() != expected {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+
+	w = respond(0, nil, errors.New("secret internals"))
+	expected = `{"ok":false,"error":"internal_error","message":"internal server error"}`
+	if w.Code != http.StatusInternalServerError || w.Body.String() != expected {
+		t.Errorf("unexpected internal error %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,132 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+// secret is a secret setting along with the file it can be read from.
+type secret struct {
+	section, name string
+	value, file   reflect.Value
+}
+
+// fileName returns the name of the setting holding the file.
+func (s secret) fileName() string {
+	return s.name + "-file"
+}
+
+// secrets returns the secret settings that can be read from a file.
+func (s *Settings) secrets() []secret {
+	var result []secret
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			if field.Tag.Get("secret") != "true" {
+				continue
+			}
+			file := section.FieldByName(field.Name + "File")
+			if !file.IsValid() {
+				continue
+			}
+			result = append(result, secret{
+				section: strings.ToLower(v.Type().Field(i).Name),
+				name:    settingName(field),
+				value:   section.Field(j),
+				file:    file,
+			})
+		}
+	}
+	return result
+}
+
+// ReadSecrets reads the secrets given as files, such as the Slack token
+// from the file named by token-file. Files are read every time the settings
+// are loaded, so that secrets can be rotated with a reload.
+func (s *Settings) ReadSecrets() Problems {
+	var problems Problems
+	for _, secret := range s.secrets() {
+		fileName := secret.file.String()
+		if fileName == "" {
+			continue
+		}
+		if secret.value.String() != "" {
+			problems = append(problems, Problem{
+				Section: secret.section,
+				Name:    secret.fileName(),
+				Message: secret.name + " and " + secret.fileName() + " are mutually exclusive",
+			})
+			continue
+		}
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			// The error only mentions the file name
+			problems = append(problems, Problem{
+				Section: secret.section,
+				Name:    secret.fileName(),
+				Message: err.Error(),
+			})
+			continue
+		}
+		secret.value.SetString(strings.TrimRight(string(data), "\r\n"))
+	}
+	return problems
+}
+
+// ClearSecret clears the setting paired with a secret or with the file
+// it is read from, such as token-file for token, so that a secret given
+// in the environment or on the command line replaces the one given in
+// the settings file. Other settings are left untouched.
+func (s *Settings) ClearSecret(section, name string) {
+	for _, secret := range s.secrets() {
+		if secret.section != section {
+			continue
+		}
+		switch name {
+		case secret.name:
+			secret.file.SetString("")
+		case secret.fileName():
+			secret.value.SetString("")
+		}
+	}
+}
+
+// SecretFiles returns the files the secrets are read from.
+func (s *Settings) SecretFiles() []string {
+	var result []string
+	for _, secret := range s.secrets() {
+		if fileName := secret.file.String(); fileName != "" {
+			result = append(result, fileName)
+		}
+	}
+	return result
+}
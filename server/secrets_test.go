@@ -0,0 +1,83 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("xoxb-secret\n")
+	f.Close()
+
+	settings := Defaults()
+	settings.Slack.TokenFile = f.Name()
+	settings.GitHub.TokenFile = "/nonexistent/github_token"
+	settings.Matrix.Token = "syt-secret"
+	settings.Matrix.TokenFile = f.Name()
+
+	problems := settings.ReadSecrets()
+	if settings.Slack.Token != "xoxb-secret" {
+		t.Errorf("expected the token from the file, got %q", settings.Slack.Token)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	if problems[0].Section != "matrix" || !strings.Contains(problems[0].Message, "mutually exclusive") {
+		t.Errorf("unexpected problem %q", problems[0].Error())
+	}
+	if problems[1].Section != "github" || problems[1].Name != "token-file" {
+		t.Errorf("unexpected problem %q", problems[1].Error())
+	}
+	for _, problem := range problems {
+		if strings.Contains(problem.Error(), "secret") {
+			t.Errorf("secret leaked in %q", problem.Error())
+		}
+	}
+
+	files := settings.SecretFiles()
+	if len(files) != 3 {
+		t.Errorf("expected 3 secret files, got %v", files)
+	}
+}
+
+func TestTokenFileVariable(t *testing.T) {
+	for _, v := range Variables() {
+		if v.Section == "slack" && v.Name == "token-file" {
+			if v.EnvName() != "SLACK_TOKEN_FILE" {
+				t.Errorf("unexpected environment variable %q", v.EnvName())
+			}
+			return
+		}
+	}
+	t.Error("expected a token-file setting for slack")
+}
@@ -0,0 +1,221 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"bufio"
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/gcfg.v1"
+)
+
+// redacted replaces secrets when printing the settings.
+const redacted = "<redacted>"
+
+// Variable is a setting that can be given outside of the settings file.
+type Variable struct {
+	Section string
+	Name    string
+	Secret  bool
+	kind    reflect.Kind
+}
+
+// IsBool returns whether the setting is a flag that needs no value.
+func (v Variable) IsBool() bool {
+	return v.kind == reflect.Bool
+}
+
+// EnvName returns the environment variable overriding the setting,
+// such as SLACK_TOKEN.
+func (v Variable) EnvName() string {
+	return strings.ToUpper(strings.Replace(v.Section+"_"+v.Name, "-", "_", -1))
+}
+
+// FlagName returns the command line flag overriding the setting,
+// such as slack.token.
+func (v Variable) FlagName() string {
+	return v.Section + "." + v.Name
+}
+
+// Variables returns the settings that can be given outside of the settings
+// file, that is those of sections without subsections that take one value.
+func Variables() []Variable {
+	var result []Variable
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			if field.Type.Kind() == reflect.Slice {
+				continue
+			}
+			result = append(result, Variable{
+				Section: strings.ToLower(section.Name),
+				Name:    settingName(field),
+				Secret:  field.Tag.Get("secret") == "true",
+				kind:    field.Type.Kind(),
+			})
+		}
+	}
+	return result
+}
+
+// Set changes a setting, parsing the value as in the settings file.
+func (s *Settings) Set(section, name, value string) error {
+	return readError(gcfg.ReadStringInto(s, "["+section+"]\n"+name+" = "+quote(value)+"\n"))
+}
+
+// SetFromEnv changes the settings given as environment variables,
+// looked up with a function such as os.LookupEnv.
+// Invalid values are returned as Problems. Secrets given in the
+// environment replace those given otherwise, as with ClearSecret.
+func (s *Settings) SetFromEnv(lookup func(string) (string, bool)) error {
+	var problems Problems
+	for _, v := range Variables() {
+		if _, ok := lookup(v.EnvName()); ok {
+			s.ClearSecret(v.Section, v.Name)
+		}
+	}
+	for _, v := range Variables() {
+		value, ok := lookup(v.EnvName())
+		if !ok {
+			continue
+		}
+		if err := s.Set(v.Section, v.Name, value); err != nil {
+			problems = append(problems, Problem{
+				Pos:     "$" + v.EnvName(),
+				Section: v.Section,
+				Name:    v.Name,
+				Message: err.Error(),
+			})
+		}
+	}
+	return problems.err()
+}
+
+// quote returns a value quoted for the settings file.
+func quote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// Write writes the settings in the format of the settings file,
+// with secrets redacted.
+func (s *Settings) Write(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.ToLower(v.Type().Field(i).Name)
+		section := v.Field(i)
+		switch section.Kind() {
+		case reflect.Struct:
+			fmt.Fprintf(out, "[%s]\n", name)
+			writeVariables(out, section)
+			fmt.Fprintln(out)
+		case reflect.Map:
+			keys := section.MapKeys()
+			sort.Sort(byString(keys))
+			for _, key := range keys {
+				fmt.Fprintf(out, "[%s %s]\n", name, quote(key.String()))
+				writeVariables(out, section.MapIndex(key).Elem())
+				fmt.Fprintln(out)
+			}
+		}
+	}
+	return out.Flush()
+}
+
+// writeVariables writes the variables of a section.
+func writeVariables(out io.Writer, section reflect.Value) {
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Type().Field(i)
+		name := settingName(field)
+		value := section.Field(i)
+		if value.Kind() == reflect.Slice {
+			for j := 0; j < value.Len(); j++ {
+				fmt.Fprintf(out, "%s = %s\n", name, formatValue(value.Index(j)))
+			}
+			continue
+		}
+		formatted := formatValue(value)
+		if field.Tag.Get("secret") == "true" && value.String() != "" {
+			formatted = quote(redacted)
+		}
+		fmt.Fprintf(out, "%s = %s\n", name, formatted)
+	}
+}
+
+// settingName returns the name of a setting in the settings file.
+func settingName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("gcfg"), ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// formatValue formats a value for the settings file.
+func formatValue(value reflect.Value) string {
+	if m, ok := value.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err == nil {
+			return quote(string(text))
+		}
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return quote(value.String())
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	}
+	return quote(fmt.Sprint(value.Interface()))
+}
+
+// byString sorts map keys.
+type byString []reflect.Value
+
+// Len returns the length of the slice.
+func (slice byString) Len() int {
+	return len(slice)
+}
+
+// Less compares two slice items and returns true if index i should go before index j.
+func (slice byString) Less(i, j int) bool {
+	return slice[i].String() < slice[j].String()
+}
+
+// Swap swaps two slice items.
+func (slice byString) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
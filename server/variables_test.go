@@ -0,0 +1,91 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/gcfg.v1"
+)
+
+func TestSetFromEnv(t *testing.T) {
+	env := map[string]string{
+		"SLACK_TOKEN":        "xoxb-secret",
+		"SERVER_READTIMEOUT": "10s",
+	}
+	settings := Defaults()
+	err := settings.SetFromEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Slack.Token != "xoxb-secret" {
+		t.Errorf("expected the token from the environment, got %q", settings.Slack.Token)
+	}
+	if settings.Server.ReadTimeout.Seconds() != 10 {
+		t.Errorf("expected a 10s read timeout, got %v", settings.Server.ReadTimeout)
+	}
+
+	env = map[string]string{"SERVER_READTIMEOUT": "soon"}
+	err = settings.SetFromEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err == nil || !strings.Contains(err.Error(), "SERVER_READTIMEOUT") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestWriteRedactsSecrets(t *testing.T) {
+	settings := Defaults()
+	settings.Slack.Token = "xoxb-secret"
+	settings.GitHub.Organization = `say "hi"`
+	settings.Mirror = map[string]*MirrorSettings{
+		"main": {URL: "https://mirror.example.org", AltURL: []string{"rsync://mirror.example.org"}},
+	}
+
+	var buf bytes.Buffer
+	if err := settings.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "xoxb-secret") {
+		t.Errorf("secret printed:\n%s", buf.String())
+	}
+
+	// The output must read back to the same settings
+	read := Defaults()
+	if err := gcfg.ReadStringInto(read, buf.String()); err != nil {
+		t.Fatal(err)
+	}
+	if read.GitHub.Organization != settings.GitHub.Organization {
+		t.Errorf("expected organization %q, got %q", settings.GitHub.Organization, read.GitHub.Organization)
+	}
+	if m := read.Mirror["main"]; m == nil || len(m.AltURL) != 1 {
+		t.Errorf("expected the mirror to be written, got %+v", m)
+	}
+}
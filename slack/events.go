@@ -0,0 +1,125 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaxRequestAge is how old a signed request can be, so that
+// captured requests can't be replayed later.
+const MaxRequestAge = 5 * time.Minute
+
+// signatureVersion is the version of the request signatures.
+const signatureVersion = "v0"
+
+// Errors of the request signature verification.
+var (
+	ErrMissingSignature = errors.New("slack: missing request signature")
+	ErrInvalidSignature = errors.New("slack: invalid request signature")
+	ErrStaleRequest     = errors.New("slack: request timestamp out of the replay window")
+)
+
+// Types of the Events API requests and events.
+const (
+	URLVerification    = "url_verification"
+	EventCallback      = "event_callback"
+	TeamJoin           = "team_join"
+	UserChange         = "user_change"
+	UserProfileChanged = "user_profile_changed"
+)
+
+// Sign returns the signature of a request body sent at the given time.
+func Sign(signingSecret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequest checks that a request of the Events API was signed
+// with the signing secret, at most MaxRequestAge before now.
+func VerifyRequest(signingSecret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > MaxRequestAge || age < -MaxRequestAge {
+		return ErrStaleRequest
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(signingSecret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// EventRequest is a request of the Events API.
+type EventRequest struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// Event is the type of an event, with the user it's about
+// for the user events.
+type Event struct {
+	Type string
+	User *User
+}
+
+// ParseEvent returns the event of an event callback.
+func (r *EventRequest) ParseEvent() (*Event, error) {
+	var e struct {
+		Type string          `json:"type"`
+		User json.RawMessage `json:"user"`
+	}
+	if err := json.Unmarshal(r.Event, &e); err != nil {
+		return nil, err
+	}
+	event := &Event{Type: e.Type}
+
+	// Other events only give the ID of the user
+	if len(e.User) > 0 && e.User[0] == '{' {
+		event.User = &User{}
+		if err := json.Unmarshal(e.User, event.User); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
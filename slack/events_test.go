@@ -0,0 +1,95 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slack
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The example of the Slack documentation on verifying requests.
+const (
+	exampleSecret    = "8f742231b10e8888abcd99yyyzzz85a5"
+	exampleTimestamp = "1531420618"
+	exampleBody      = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	exampleSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	sent := time.Unix(1531420618, 0)
+	header := func(timestamp, signature string) http.Header {
+		h := http.Header{}
+		if timestamp != "" {
+			h.Set("X-Slack-Request-Timestamp", timestamp)
+		}
+		if signature != "" {
+			h.Set("X-Slack-Signature", signature)
+		}
+		return h
+	}
+	tests := []struct {
+		name     string
+		header   http.Header
+		body     string
+		secret   string
+		now      time.Time
+		expected error
+	}{
+		{"valid", header(exampleTimestamp, exampleSignature), exampleBody, exampleSecret, sent, nil},
+		{"late but in the window", header(exampleTimestamp, exampleSignature), exampleBody, exampleSecret, sent.Add(4 * time.Minute), nil},
+		{"replayed", header(exampleTimestamp, exampleSignature), exampleBody, exampleSecret, sent.Add(6 * time.Minute), ErrStaleRequest},
+		{"from the future", header(exampleTimestamp, exampleSignature), exampleBody, exampleSecret, sent.Add(-6 * time.Minute), ErrStaleRequest},
+		{"tampered body", header(exampleTimestamp, exampleSignature), exampleBody + "&admin=1", exampleSecret, sent, ErrInvalidSignature},
+		{"wrong secret", header(exampleTimestamp, exampleSignature), exampleBody, "secret", sent, ErrInvalidSignature},
+		{"tampered timestamp", header(strconv.Itoa(1531420619), exampleSignature), exampleBody, exampleSecret, sent, ErrInvalidSignature},
+		{"invalid timestamp", header("yesterday", exampleSignature), exampleBody, exampleSecret, sent, ErrInvalidSignature},
+		{"missing signature", header(exampleTimestamp, ""), exampleBody, exampleSecret, sent, ErrMissingSignature},
+		{"missing timestamp", header("", exampleSignature), exampleBody, exampleSecret, sent, ErrMissingSignature},
+	}
+	for _, test := range tests {
+		err := VerifyRequest(test.secret, test.header, []byte(test.body), test.now)
+		if err != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	r := EventRequest{Event: []byte(`{"type": "user_change", "user": {"id": "U1", "name": "alice"}}`)}
+	e, err := r.ParseEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != UserChange || e.User == nil || e.User.ID != "U1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// Most events only have the ID of the user
+	r = EventRequest{Event: []byte(`{"type": "message", "user": "U1"}`)}
+	if e, err := r.ParseEvent(); err != nil || e.User != nil {
+		t.Errorf("unexpected event %+v, %v", e, err)
+	}
+}
@@ -0,0 +1,44 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slack
+
+import (
+	"net/url"
+)
+
+// Errors of users.admin.invite for people who needn't be invited.
+const (
+	AlreadyInTeam        = "already_in_team"
+	AlreadyInTeamInvited = "already_in_team_invited_user"
+	AlreadyInvited       = "already_invited"
+	SentRecently         = "sent_recently"
+	InvalidEmail         = "invalid_email"
+)
+
+// InviteUser sends an invitation to join the workspace to an email
+// address. It needs a token allowed to invite people.
+func (c *Client) InviteUser(email string) error {
+	var r response
+	return c.Post("users.admin.invite", url.Values{"email": {email}}, &r)
+}
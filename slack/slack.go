@@ -0,0 +1,229 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+// Package slack is a client for the Slack Web API.
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRetryAfter is how long to wait when rate limited
+// without being told for how long.
+const defaultRetryAfter = 30 * time.Second
+
+// Pagination defaults and limits.
+const (
+	DefaultPageSize = 200
+	MaxPageSize     = 1000
+	DefaultMaxWait  = time.Minute
+)
+
+// Error is an error code returned by a method of the API,
+// such as invalid_auth.
+type Error struct {
+	Method string
+	Code   string
+}
+
+func (e *Error) Error() string {
+	return "slack: " + e.Method + ": " + e.Code
+}
+
+// IsAuth returns whether the error is caused by the token.
+func (e *Error) IsAuth() bool {
+	switch e.Code {
+	case "not_authed", "invalid_auth", "account_inactive", "token_revoked",
+		"token_expired", "no_permission", "missing_scope", "not_allowed_token_type":
+		return true
+	}
+	return false
+}
+
+// IsTemporary returns whether the error is caused by a Slack outage.
+func (e *Error) IsTemporary() bool {
+	switch e.Code {
+	case "fatal_error", "internal_error", "request_timeout", "service_unavailable":
+		return true
+	}
+	return false
+}
+
+// RateLimitError is returned when Slack is rate limiting the requests.
+type RateLimitError struct {
+	Method string
+	Delay  time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("slack: %s: rate limited, retry after %s", e.Method, e.Delay)
+}
+
+// RetryAfter returns how long to wait before trying again.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return e.Delay
+}
+
+// StatusError is returned when Slack answers with an unexpected
+// HTTP status code.
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("slack: %s: unexpected response %q", e.Method, e.Status)
+}
+
+// response is the part common to all the responses.
+type response struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// Client calls the Slack Web API with a token.
+type Client struct {
+	URL        string
+	Token      string
+	HTTPClient *http.Client
+
+	// PageSize is how many items paginated methods ask for at once.
+	PageSize int
+	// MaxWait limits how long to wait when rate limited in the middle
+	// of a paginated method.
+	MaxWait time.Duration
+
+	sleep func(time.Duration)
+}
+
+// NewClient returns a client for the API at baseURL, such as
+// https://slack.com/api, authenticating with token.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		URL:      strings.TrimSuffix(baseURL, "/"),
+		Token:    token,
+		PageSize: DefaultPageSize,
+		MaxWait:  DefaultMaxWait,
+		sleep:    time.Sleep,
+	}
+}
+
+// Call calls a method of the API with some arguments and decodes
+// the response into v. The token is sent in the Authorization header,
+// so that it never appears in URLs.
+func (c *Client) Call(method string, args url.Values, v interface{}) error {
+	u := c.URL + "/" + method
+	if len(args) > 0 {
+		u += "?" + args.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(method, req, v)
+}
+
+// Post calls a method of the API like Call, sending the arguments
+// as a form so that personal data such as email addresses doesn't
+// end up in URLs and logs.
+func (c *Client) Post(method string, args url.Values, v interface{}) error {
+	req, err := http.NewRequest("POST", c.URL+"/"+method, strings.NewReader(args.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(method, req, v)
+}
+
+// do sends the request of a method and decodes the response into v.
+func (c *Client) do(method string, req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{Method: method, Delay: retryAfter(resp.Header)}
+	case resp.StatusCode != http.StatusOK:
+		return &StatusError{Method: method, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return fmt.Errorf("slack: %s: %v", method, err)
+	}
+	if !r.Ok {
+		if r.Error == "ratelimited" {
+			return &RateLimitError{Method: method, Delay: retryAfter(resp.Header)}
+		}
+		return &Error{Method: method, Code: r.Error}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("slack: %s: %v", method, err)
+	}
+	return nil
+}
+
+// callPage calls a paginated method for a page after the first,
+// waiting and trying again when rate limited, so that the pages
+// already read are not lost, unless Slack asks to wait too long.
+func (c *Client) callPage(method string, args url.Values, v interface{}) error {
+	var waited time.Duration
+	for {
+		err := c.Call(method, args, v)
+		e, ok := err.(*RateLimitError)
+		if !ok || waited+e.Delay > c.MaxWait {
+			return err
+		}
+		c.sleep(e.Delay)
+		waited += e.Delay
+	}
+}
+
+// retryAfter returns the delay asked by the Retry-After header.
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,212 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeSlack is a stand-in for the Slack Web API.
+type fakeSlack struct {
+	status int
+	header http.Header
+	body   string
+	req    *http.Request
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.req = r
+	if r.Header.Get("Authorization") != "Bearer xoxb-test" {
+		w.Write([]byte(`{"ok": false, "error": "not_authed"}`))
+		return
+	}
+	for name, values := range f.header {
+		w.Header()[name] = values
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	w.Write([]byte(f.body))
+}
+
+func TestUsersList(t *testing.T) {
+	fake := &fakeSlack{body: `{
+		"ok": true,
+		"members": [
+			{"id": "U1", "name": "alice", "real_name": "Alice", "is_admin": true,
+			 "profile": {"image_512": "https://example.com/alice.png"}, "presence": "active"}
+		]
+	}`}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	users, err := NewClient(ts.URL+"/", "xoxb-test").UsersList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("expected 1 user, got %d", len(users))
+	}
+	u := users[0]
+	if u.ID != "U1" || u.RealName != "Alice" || !u.IsAdmin || u.Presence != "active" || u.Profile.Image512 == "" {
+		t.Errorf("unexpected user %+v", u)
+	}
+	if fake.req.URL.Path != "/users.list" || fake.req.URL.Query().Get("presence") != "1" {
+		t.Errorf("unexpected request %s", fake.req.URL)
+	}
+	if fake.req.URL.Query().Get("token") != "" {
+		t.Error("the token must not be sent in the URL")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		fake  *fakeSlack
+		token string
+		check func(error) bool
+	}{
+		{&fakeSlack{body: `{"ok": true}`}, "xoxb-wrong", func(err error) bool {
+			e, ok := err.(*Error)
+			return ok && e.Code == "not_authed" && e.IsAuth()
+		}},
+		{&fakeSlack{body: `{"ok": false, "error": "fatal_error"}`}, "xoxb-test", func(err error) bool {
+			e, ok := err.(*Error)
+			return ok && e.IsTemporary() && !e.IsAuth()
+		}},
+		{&fakeSlack{status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"42"}}}, "xoxb-test", func(err error) bool {
+			e, ok := err.(*RateLimitError)
+			return ok && e.RetryAfter() == 42*time.Second
+		}},
+		{&fakeSlack{body: `{"ok": false, "error": "ratelimited"}`}, "xoxb-test", func(err error) bool {
+			e, ok := err.(*RateLimitError)
+			return ok && e.RetryAfter() == defaultRetryAfter
+		}},
+		{&fakeSlack{status: http.StatusBadGateway}, "xoxb-test", func(err error) bool {
+			e, ok := err.(*StatusError)
+			return ok && e.StatusCode == http.StatusBadGateway
+		}},
+		{&fakeSlack{body: `<html>`}, "xoxb-test", func(err error) bool {
+			return err != nil
+		}},
+	}
+	for i, test := range tests {
+		ts := httptest.NewServer(test.fake)
+		_, err := NewClient(ts.URL, test.token).UsersList()
+		ts.Close()
+		if !test.check(err) {
+			t.Errorf("%d: unexpected error %#v", i, err)
+		}
+	}
+}
+
+// pagedSlack serves users.list in pages of one user, rate limiting
+// the request for the second page a number of times.
+type pagedSlack struct {
+	limited int
+	limits  []string
+}
+
+func (p *pagedSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.limits = append(p.limits, r.URL.Query().Get("limit"))
+	switch r.URL.Query().Get("cursor") {
+	case "":
+		w.Write([]byte(`{"ok": true, "members": [{"id": "U1"}], "response_metadata": {"next_cursor": "c2"}}`))
+	case "c2":
+		if p.limited > 0 {
+			p.limited--
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"ok": true, "members": [{"id": "U2"}], "response_metadata": {"next_cursor": "c3"}}`))
+	case "c3":
+		w.Write([]byte(`{"ok": true, "members": [{"id": "U3"}], "response_metadata": {"next_cursor": ""}}`))
+	default:
+		w.Write([]byte(`{"ok": false, "error": "invalid_cursor"}`))
+	}
+}
+
+func TestUsersListPagination(t *testing.T) {
+	fake := &pagedSlack{limited: 2}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	var slept time.Duration
+	client := NewClient(ts.URL, "xoxb-test")
+	client.PageSize = 1
+	client.sleep = func(d time.Duration) { slept += d }
+
+	users, err := client.UsersList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0].ID != "U1" || users[2].ID != "U3" {
+		t.Errorf("expected the full roster, got %+v", users)
+	}
+	if slept != 6*time.Second {
+		t.Errorf("expected to wait 6s for the rate limit, waited %s", slept)
+	}
+	for _, limit := range fake.limits {
+		if limit != "1" {
+			t.Errorf("expected a page size of 1, got %q", limit)
+		}
+	}
+
+	// Waiting longer than allowed gives up
+	fake.limited = 2
+	client.MaxWait = 5 * time.Second
+	if _, err := client.UsersList(); err == nil {
+		t.Error("expected a rate limit error")
+	} else if _, ok := err.(*RateLimitError); !ok {
+		t.Errorf("expected a rate limit error, got %v", err)
+	}
+}
+
+func TestInviteUser(t *testing.T) {
+	var method, email, query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, email, query = r.Method, r.PostFormValue("email"), r.URL.RawQuery
+		if email == "alice@example.org" {
+			w.Write([]byte(`{"ok": false, "error": "already_in_team"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer ts.Close()
+	client := NewClient(ts.URL, "xoxb-test")
+
+	if err := client.InviteUser("bob@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if method != "POST" || email != "bob@example.org" || query != "" {
+		t.Errorf("expected the address to be posted, got %s %q with query %q", method, email, query)
+	}
+	err := client.InviteUser("alice@example.org")
+	if e, ok := err.(*Error); !ok || e.Code != AlreadyInTeam {
+		t.Errorf("expected %s, got %v", AlreadyInTeam, err)
+	}
+}
@@ -0,0 +1,104 @@
+/****************************************************************************
+ * This file is part of Liri.
+ *
+ * Copyright (C) 2017 Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slack
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Profile contains the profile of a user.
+type Profile struct {
+	Image24   string `json:"image_24"`
+	Image32   string `json:"image_32"`
+	Image48   string `json:"image_48"`
+	Image72   string `json:"image_72"`
+	Image192  string `json:"image_192"`
+	Image512  string `json:"image_512"`
+	Image1024 string `json:"image_1024"`
+}
+
+// User is a member of a workspace.
+type User struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	RealName string  `json:"real_name"`
+	TzLabel  string  `json:"tz_label"`
+	Tz       string  `json:"tz"`
+	TzOffset int     `json:"tz_offset"`
+	Profile  Profile `json:"profile"`
+	IsBot    bool    `json:"is_bot"`
+	IsAdmin  bool    `json:"is_admin"`
+	IsOwner  bool    `json:"is_owner"`
+	Deleted  bool    `json:"deleted"`
+	Presence string  `json:"presence,omitempty"`
+
+	IsPrimaryOwner    bool `json:"is_primary_owner"`
+	IsRestricted      bool `json:"is_restricted"`
+	IsUltraRestricted bool `json:"is_ultra_restricted"`
+}
+
+// usersListResponse is a page of the response of users.list.
+type usersListResponse struct {
+	Members          []User `json:"members"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// UsersList returns all the members of the workspace along with their
+// presence, following the pagination cursors.
+func (c *Client) UsersList() ([]User, error) {
+	limit := c.PageSize
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	args := url.Values{
+		"presence": {"1"},
+		"limit":    {strconv.Itoa(limit)},
+	}
+
+	var users []User
+	for {
+		var r usersListResponse
+		var err error
+		if args.Get("cursor") == "" {
+			err = c.Call("users.list", args, &r)
+		} else {
+			err = c.callPage("users.list", args, &r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, r.Members...)
+		if r.ResponseMetadata.NextCursor == "" {
+			return users, nil
+		}
+		if r.ResponseMetadata.NextCursor == args.Get("cursor") {
+			return nil, fmt.Errorf("slack: users.list: cursor %q repeated", args.Get("cursor"))
+		}
+		args.Set("cursor", r.ResponseMetadata.NextCursor)
+	}
+}